@@ -0,0 +1,254 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+
+	"github.com/samkreter/go-core/httputil"
+	"github.com/samkreter/go-core/log"
+	"github.com/samkreter/go-core/log/hooks"
+	"github.com/samkreter/go-core/trace"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Paths of the admin endpoints
+const (
+	PprofPath          = "/debug/pprof/"
+	BuildInfoPath      = "/buildinfo"
+	LogLevelPath       = "/loglevel"
+	TraceSamplerPath   = "/trace/sampler"
+	LoggingHubHookPath = "/logginghub/levels"
+)
+
+// Authorizer returns an error when the request is not allowed to use the admin endpoints
+type Authorizer func(req *http.Request) error
+
+// BuildInfo is the build information of the service, usually set with -ldflags -X
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Config holds the configuration for the admin handler
+type Config struct {
+	// Authorize is required, it checks every admin request
+	Authorize Authorizer
+
+	BuildInfo BuildInfo
+
+	// LoggingHubHook enables reading and changing the levels of the hook when set
+	LoggingHubHook *hooks.LoggingHubHook
+
+	// Handlers are mounted with the same authorization, e.g. the OpenCensus zpages which are
+	// not vendored with go-core: zpages.Handle(mux, "/debug") on a mux mounted at "/debug/"
+	Handlers map[string]http.Handler
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+type traceSampler struct {
+	Probability float64 `json:"probability"`
+}
+
+type hookLevels struct {
+	Levels []string `json:"levels"`
+}
+
+// NewHandler creates the admin handler, it is meant to be served on a separate port
+func NewHandler(config Config) (http.Handler, error) {
+	if config.Authorize == nil {
+		return nil, errors.New("configuration must have an Authorize function")
+	}
+
+	if config.BuildInfo.GoVersion == "" {
+		config.BuildInfo.GoVersion = runtime.Version()
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(PprofPath, pprof.Index)
+	mux.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPath+"trace", pprof.Trace)
+
+	mux.HandleFunc(BuildInfoPath, func(w http.ResponseWriter, req *http.Request) {
+		if !allowMethods(w, req, "GET") {
+			return
+		}
+		writeJSON(w, req, config.BuildInfo)
+	})
+
+	mux.HandleFunc(LogLevelPath, handleLogLevel)
+	mux.HandleFunc(TraceSamplerPath, handleTraceSampler)
+
+	if config.LoggingHubHook != nil {
+		mux.HandleFunc(LoggingHubHookPath, func(w http.ResponseWriter, req *http.Request) {
+			handleHookLevels(w, req, config.LoggingHubHook)
+		})
+	}
+
+	for pattern, handler := range config.Handlers {
+		mux.Handle(pattern, handler)
+	}
+
+	return authorize(config.Authorize, mux), nil
+}
+
+// TokenAuthorizer allows the requests with the bearer token in the Authorization header.
+// An empty token denies every request, e.g. when the token is missing from the environment.
+func TokenAuthorizer(token string) Authorizer {
+	return func(req *http.Request) error {
+		if token == "" {
+			return errors.New("no admin token configured")
+		}
+
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			return errors.New("invalid admin token")
+		}
+
+		return nil
+	}
+}
+
+func authorize(authorizer Authorizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := authorizer(req); err != nil {
+			log.G(req.Context()).WithError(err).WithFields(log.Fields{
+				"path":     req.URL.Path,
+				"clientIP": httputil.ClientIP(req),
+			}).Warn("Unauthorized admin request")
+
+			httputil.WriteProblem(w, req, httputil.NewProblem(http.StatusUnauthorized, "the admin request is not authorized"))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func handleLogLevel(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, "GET", "PUT") {
+		return
+	}
+
+	if req.Method == "PUT" {
+		var body logLevel
+		if !readJSON(w, req, &body) {
+			return
+		}
+
+		previous := log.L.GetLevel().String()
+		if err := log.SetLogLevel(body.Level); err != nil {
+			httputil.WriteProblem(w, req, httputil.NewProblem(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		log.G(req.Context()).WithFields(log.Fields{
+			"previousLevel": previous,
+			"level":         log.L.GetLevel().String(),
+		}).Warn("Changed the log level")
+	}
+
+	writeJSON(w, req, logLevel{Level: log.L.GetLevel().String()})
+}
+
+func handleTraceSampler(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, "GET", "PUT") {
+		return
+	}
+
+	if req.Method == "PUT" {
+		var body traceSampler
+		if !readJSON(w, req, &body) {
+			return
+		}
+
+		previous := trace.SamplingProbability()
+		if err := trace.SetSamplingProbability(body.Probability); err != nil {
+			httputil.WriteProblem(w, req, httputil.NewProblem(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		log.G(req.Context()).WithFields(log.Fields{
+			"previousProbability": previous,
+			"probability":         body.Probability,
+		}).Warn("Changed the trace sampling probability")
+	}
+
+	writeJSON(w, req, traceSampler{Probability: trace.SamplingProbability()})
+}
+
+func handleHookLevels(w http.ResponseWriter, req *http.Request, hook *hooks.LoggingHubHook) {
+	if !allowMethods(w, req, "GET", "PUT") {
+		return
+	}
+
+	if req.Method == "PUT" {
+		var body hookLevels
+		if !readJSON(w, req, &body) {
+			return
+		}
+
+		levels := make([]logrus.Level, 0, len(body.Levels))
+		for _, l := range body.Levels {
+			level, err := log.ParseLevel(l)
+			if err != nil {
+				httputil.WriteProblem(w, req, httputil.NewProblem(http.StatusBadRequest, err.Error()))
+				return
+			}
+			levels = append(levels, logrus.Level(level))
+		}
+
+		hook.SetLevels(levels)
+
+		log.G(req.Context()).WithField("levels", body.Levels).Warn("Changed the LoggingHub hook levels")
+	}
+
+	resp := hookLevels{Levels: []string{}}
+	for _, level := range hook.EnabledLevels() {
+		resp.Levels = append(resp.Levels, level.String())
+	}
+
+	writeJSON(w, req, resp)
+}
+
+func allowMethods(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	httputil.WriteProblem(w, req, httputil.NewProblem(http.StatusMethodNotAllowed, ""))
+	return false
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		httputil.WriteProblem(w, req, httputil.NewProblem(http.StatusBadRequest, "invalid JSON body: "+err.Error()))
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.G(req.Context()).WithError(err).Error("Failed to write the admin response")
+	}
+}
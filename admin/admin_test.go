@@ -0,0 +1,117 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/samkreter/go-core/httputil"
+	"github.com/samkreter/go-core/log"
+	"github.com/samkreter/go-core/log/hooks"
+	"github.com/samkreter/go-core/trace"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "test-token"
+
+func adminRequest(t *testing.T, handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err, "Should not get error when creating a request")
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	_, err := NewHandler(Config{})
+	assert.Error(t, err, "Should require an Authorize function")
+
+	handler, err := NewHandler(Config{Authorize: TokenAuthorizer(testToken)})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", LogLevelPath, nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Should reject a request without the token")
+
+	rr = adminRequest(t, handler, "GET", BuildInfoPath, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"goVersion":"go`)
+
+	handler, err = NewHandler(Config{Authorize: TokenAuthorizer("")})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", LogLevelPath, nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Should reject every request when the token is empty")
+}
+
+func TestAdminAuthorizationClientIP(t *testing.T) {
+	handler, err := NewHandler(Config{Authorize: TokenAuthorizer(testToken)})
+	require.NoError(t, err)
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	req := httptest.NewRequest("GET", LogLevelPath, nil)
+	req = req.WithContext(httputil.WithClientInfo(req.Context(), httputil.ClientInfo{IP: "203.0.113.7"}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.NotNil(t, testHook.LastEntry())
+	assert.Equal(t, "Unauthorized admin request", testHook.LastEntry().Message)
+	assert.Equal(t, "203.0.113.7", testHook.LastEntry().Data["clientIP"], "Should log the resolved client IP")
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	defer log.SetLogLevel(log.L.GetLevel().String())
+	require.NoError(t, log.SetLogLevel("info"))
+
+	handler, err := NewHandler(Config{Authorize: TokenAuthorizer(testToken)})
+	require.NoError(t, err)
+
+	rr := adminRequest(t, handler, "PUT", LogLevelPath, `{"level":"debug"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, log.DebugLevel, log.L.GetLevel(), "Should change the log level")
+	assert.JSONEq(t, `{"level":"debug"}`, rr.Body.String())
+
+	rr = adminRequest(t, handler, "PUT", LogLevelPath, `{"level":"loud"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should reject an unknown level")
+
+	rr = adminRequest(t, handler, "DELETE", LogLevelPath, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestAdminTraceSampler(t *testing.T) {
+	defer trace.SetSamplingProbability(trace.SamplingProbability())
+
+	handler, err := NewHandler(Config{Authorize: TokenAuthorizer(testToken)})
+	require.NoError(t, err)
+
+	rr := adminRequest(t, handler, "PUT", TraceSamplerPath, `{"probability":0.25}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 0.25, trace.SamplingProbability(), "Should change the sampling probability")
+
+	rr = adminRequest(t, handler, "PUT", TraceSamplerPath, `{"probability":2}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Should reject a probability over 1")
+}
+
+func TestAdminLoggingHubLevels(t *testing.T) {
+	hook, err := hooks.NewLoggingHubHook("http://localhost:0", []string{"test"})
+	require.NoError(t, err)
+
+	handler, err := NewHandler(Config{Authorize: TokenAuthorizer(testToken), LoggingHubHook: hook})
+	require.NoError(t, err)
+
+	rr := adminRequest(t, handler, "PUT", LoggingHubHookPath, `{"levels":["error","warning"]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"levels":["error","warning"]}`, rr.Body.String())
+	assert.Equal(t, []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel}, hook.EnabledLevels())
+}
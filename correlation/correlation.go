@@ -7,7 +7,6 @@ import (
 	"github.com/samkreter/go-core/log"
 
 	uuid "github.com/satori/go.uuid"
-	"github.com/sirupsen/logrus"
 )
 
 type contextKey string
@@ -63,6 +62,25 @@ func (m ContextMatadataHeaders) FromReq(req *http.Request) {
 	m.Add(req, AcceptedLanguageHeader)
 }
 
+func init() {
+	log.RegisterContextFields(contextFields)
+}
+
+// contextFields returns the correlation fields of the context for the slog records,
+// the metadata headers are added with the header names as keys
+func contextFields(ctx context.Context) log.Fields {
+	fields := log.Fields{
+		"correlationID": GetCorrelationID(ctx),
+		"activityID":    GetActivityID(ctx),
+	}
+
+	for key, val := range GetMetadataHeaders(ctx) {
+		fields[key] = val
+	}
+
+	return fields
+}
+
 func generateGUID() string {
 	guid := uuid.NewV4()
 	return guid.String()
@@ -70,7 +88,7 @@ func generateGUID() string {
 
 // AddCorrelationLogger adds the correlation information to the context logger
 func AddCorrelationLogger(ctx context.Context) context.Context {
-	logger := log.G(ctx).WithFields(logrus.Fields{
+	logger := log.G(ctx).WithFields(log.Fields{
 		"correlationID": GetCorrelationID(ctx),
 		"activityID":    GetActivityID(ctx),
 	})
@@ -132,6 +150,9 @@ func CreateCtxFromRequest(req *http.Request) context.Context {
 		correlationID = generateGUID()
 	}
 
+	// Escalate before the correlation fields are added so they are on the escalated logger
+	ctx = setDebugFromRequest(ctx, req, correlationID)
+
 	ctx = SetCorrelationID(ctx, correlationID)
 
 	ctx = SetActivityID(ctx, "")
@@ -156,6 +177,11 @@ func AddHeadersFromContext(ctx context.Context, req *http.Request) {
 
 	req.Header.Set(RequestIDHeader, generateGUID())
 
+	// Forward the signed debug escalation so every hop of the request chain escalates
+	if debug, ok := ctx.Value(debugContextKey).(*debugRequest); ok && debug.header != "" && req.Header.Get(DebugHeader) == "" {
+		req.Header.Set(DebugHeader, debug.header)
+	}
+
 	metadataHeaders := GetMetadataHeaders(ctx)
 	if metadataHeaders == nil {
 		return
@@ -2,9 +2,13 @@ package correlation
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"testing"
 
+	"github.com/samkreter/go-core/log"
+
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -72,3 +76,24 @@ func AddStandardRequestHeaders(req *http.Request) {
 	req.Header.Set("Accept-Language", "test-langauge")
 	req.Header.Set("Content-Type", "application/json")
 }
+
+func TestSlogContextFields(t *testing.T) {
+	logger, hook := logrustest.NewNullLogger()
+	slogger := slog.New(log.NewContextHandler(log.NewLogrusHandler(logger)))
+
+	req, err := http.NewRequest("GET", "example.com", nil)
+	require.NoError(t, err, "Should not get error creating request.")
+	req.Header.Set(CorrelationIDHeader, testCorrelationID)
+	req.Header.Set(UserAgentHeader, "test-agent")
+
+	ctx := CreateCtxFromRequest(req)
+	slogger.WithGroup("request").InfoContext(ctx, "Slog record", "key", "value")
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry, "Should route the record to logrus")
+	assert.Equal(t, "Slog record", entry.Message)
+	assert.Equal(t, testCorrelationID, entry.Data["correlationID"], "Should add the correlation ID outside the groups")
+	assert.Equal(t, GetActivityID(ctx), entry.Data["activityID"], "Should add the activity ID")
+	assert.Equal(t, "test-agent", entry.Data[UserAgentHeader], "Should add the metadata headers")
+	assert.Equal(t, "value", entry.Data["request.key"])
+}
@@ -0,0 +1,264 @@
+package correlation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	// debugContextKey the debug escalation context key
+	debugContextKey = contextKey("debug")
+)
+
+var (
+	// DebugHeader requests an elevated log level and sampled traces for the request chain.
+	// The value is "level=<level>;expires=<unix seconds>;signature=<signature>",
+	// the signature is not required for callers in the allowed networks.
+	DebugHeader = "debug-request"
+
+	debugConfigMu sync.RWMutex
+	debugConfig   *debugVerifier
+	clientIPFunc  = remoteIP
+)
+
+// DebugConfig holds the configuration of the per-request debug escalation
+type DebugConfig struct {
+	// Key verifies the signature of the debug header
+	Key []byte
+
+	// AllowedNetworks are the CIDRs of the callers that can escalate without a signature.
+	// The escalation of an unsigned header still expires and is not forwarded to the downstream services.
+	AllowedNetworks []string
+}
+
+type debugVerifier struct {
+	key      []byte
+	networks []*net.IPNet
+}
+
+// debugRequest is the verified debug escalation of a request
+type debugRequest struct {
+	level log.Level
+
+	// header is forwarded to the downstream services, empty for an unsigned escalation
+	header string
+}
+
+// EnableDebugEscalation makes CreateCtxFromRequest honor the DebugHeader, the header is ignored by default
+func EnableDebugEscalation(config DebugConfig) error {
+	if len(config.Key) == 0 && len(config.AllowedNetworks) == 0 {
+		return errors.New("configuration must have a Key or AllowedNetworks")
+	}
+
+	verifier := &debugVerifier{key: config.Key}
+	for _, cidr := range config.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		verifier.networks = append(verifier.networks, network)
+	}
+
+	debugConfigMu.Lock()
+	defer debugConfigMu.Unlock()
+
+	debugConfig = verifier
+	return nil
+}
+
+// DisableDebugEscalation makes CreateCtxFromRequest ignore the DebugHeader
+func DisableDebugEscalation() {
+	debugConfigMu.Lock()
+	defer debugConfigMu.Unlock()
+
+	debugConfig = nil
+}
+
+// SetClientIPFunc sets the function returning the client IP checked against the AllowedNetworks,
+// the httputil package sets it to the client resolved behind the trusted proxies
+func SetClientIPFunc(fn func(req *http.Request) string) {
+	debugConfigMu.Lock()
+	defer debugConfigMu.Unlock()
+
+	clientIPFunc = fn
+}
+
+// SignDebugHeader returns the value of the DebugHeader for the correlation ID, the signature
+// binds the escalation to the request chain of the correlation ID until it expires
+func SignDebugHeader(key []byte, correlationID, level string, expires time.Time) string {
+	payload := fmt.Sprintf("level=%s;expires=%d", level, expires.Unix())
+	return payload + ";signature=" + debugSignature(key, correlationID, payload)
+}
+
+// GetDebugLevel gets the escalated log level of the request from a context
+func GetDebugLevel(ctx context.Context) (log.Level, bool) {
+	debug, ok := ctx.Value(debugContextKey).(*debugRequest)
+	if !ok {
+		return 0, false
+	}
+	return debug.level, true
+}
+
+// setDebugFromRequest escalates the context logger when the request has a valid DebugHeader
+func setDebugFromRequest(ctx context.Context, req *http.Request, correlationID string) context.Context {
+	header := req.Header.Get(DebugHeader)
+	if header == "" {
+		return ctx
+	}
+
+	debugConfigMu.RLock()
+	verifier := debugConfig
+	clientIP := clientIPFunc
+	debugConfigMu.RUnlock()
+
+	if verifier == nil {
+		return ctx
+	}
+
+	level, signed, err := verifier.verify(clientIP(req), correlationID, header)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("correlationID", correlationID).Warn("Ignored invalid debug header")
+		return ctx
+	}
+
+	debug := &debugRequest{level: level}
+	if signed {
+		debug.header = header
+	}
+	ctx = context.WithValue(ctx, debugContextKey, debug)
+
+	// The escalation is still forwarded when the level of this service is already high enough
+	if logger := log.G(ctx); level > logger.GetLevel() {
+		ctx = log.WithLogger(ctx, logger.WithLevel(level))
+	}
+
+	log.G(ctx).WithFields(log.Fields{
+		"correlationID": correlationID,
+		"debugLevel":    level.String(),
+	}).Info("Escalated the log level for the request")
+
+	return ctx
+}
+
+// verify returns the level of the header and whether it is signed,
+// the callers in the allowed networks can send an unsigned header
+func (v *debugVerifier) verify(clientIP, correlationID, header string) (log.Level, bool, error) {
+	payload, signature, signed := header, "", false
+	if i := strings.Index(header, ";signature="); i >= 0 {
+		payload, signature, signed = header[:i], header[i+len(";signature="):], true
+	}
+
+	// Only the signed payload is parsed so nothing can be added after the signature
+	fields, err := parseDebugPayload(payload)
+	if err != nil {
+		return 0, false, err
+	}
+
+	level, err := log.ParseLevel(fields["level"])
+	if err != nil {
+		return 0, false, err
+	}
+
+	expires, err := strconv.ParseInt(fields["expires"], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid debug header expiry: %v", err)
+	}
+
+	if time.Now().After(time.Unix(expires, 0)) {
+		return 0, false, errors.New("debug header expired")
+	}
+
+	err = v.verifySignature(correlationID, payload, signature, signed)
+	if err == nil {
+		return level, true, nil
+	}
+
+	if v.allowedCaller(clientIP) {
+		return level, false, nil
+	}
+
+	return 0, false, err
+}
+
+func (v *debugVerifier) verifySignature(correlationID, payload, signature string, signed bool) error {
+	if len(v.key) == 0 {
+		return errors.New("caller is not allowed to escalate")
+	}
+
+	if !signed || signature == "" {
+		return errors.New("debug header is not signed")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(debugSignature(v.key, correlationID, payload))) {
+		return errors.New("invalid debug header signature")
+	}
+
+	return nil
+}
+
+// parseDebugPayload returns the level and expires fields of the payload,
+// it rejects the unknown and duplicated fields
+func parseDebugPayload(payload string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(payload, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid debug header field %q", part)
+		}
+
+		if kv[0] != "level" && kv[0] != "expires" {
+			return nil, fmt.Errorf("unknown debug header field %q", kv[0])
+		}
+
+		if _, ok := fields[kv[0]]; ok {
+			return nil, fmt.Errorf("duplicated debug header field %q", kv[0])
+		}
+
+		fields[kv[0]] = kv[1]
+	}
+
+	return fields, nil
+}
+
+func (v *debugVerifier) allowedCaller(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range v.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the address of the connected client
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+func debugSignature(key []byte, correlationID, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(correlationID + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
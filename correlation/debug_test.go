@@ -0,0 +1,116 @@
+package correlation
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDebugKey = []byte("test-debug-key")
+
+func newDebugRequest(t *testing.T, remoteAddr, header string) *http.Request {
+	req, err := http.NewRequest("GET", "example.com", nil)
+	require.NoError(t, err, "Should not get error creating request.")
+	req.RemoteAddr = remoteAddr
+	req.Header.Set(CorrelationIDHeader, testCorrelationID)
+	if header != "" {
+		req.Header.Set(DebugHeader, header)
+	}
+
+	return req
+}
+
+func TestDebugEscalation(t *testing.T) {
+	defer log.SetLogLevel(log.L.GetLevel().String())
+	require.NoError(t, log.SetLogLevel("info"))
+	hook := logrustest.NewGlobal()
+
+	require.NoError(t, EnableDebugEscalation(DebugConfig{
+		Key:             testDebugKey,
+		AllowedNetworks: []string{"10.0.0.0/8"},
+	}))
+	defer DisableDebugEscalation()
+
+	signed := SignDebugHeader(testDebugKey, testCorrelationID, "trace", time.Now().Add(time.Hour))
+	unsigned := fmt.Sprintf("level=debug;expires=%d", time.Now().Add(time.Hour).Unix())
+
+	tt := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		escalated  bool
+		forwarded  bool
+	}{
+		{"No header", "192.0.2.1:1234", "", false, false},
+		{"Signed", "192.0.2.1:1234", signed, true, true},
+		{"Allowed network", "10.1.2.3:1234", unsigned, true, false},
+		{"Allowed network signed", "10.1.2.3:1234", signed, true, true},
+		{"Allowed network without expiry", "10.1.2.3:1234", "level=debug", false, false},
+		{"Allowed network expired", "10.1.2.3:1234", fmt.Sprintf("level=debug;expires=%d", time.Now().Add(-time.Minute).Unix()), false, false},
+		{"Unsigned", "192.0.2.1:1234", unsigned, false, false},
+		{"Other correlation ID", "192.0.2.1:1234", SignDebugHeader(testDebugKey, "other", "trace", time.Now().Add(time.Hour)), false, false},
+		{"Expired", "192.0.2.1:1234", SignDebugHeader(testDebugKey, testCorrelationID, "trace", time.Now().Add(-time.Minute)), false, false},
+		{"Appended fields", "192.0.2.1:1234", SignDebugHeader(testDebugKey, testCorrelationID, "info", time.Now().Add(-time.Minute)) + ";expires=9999999999;level=trace", false, false},
+		{"Duplicated field", "192.0.2.1:1234", "level=info;level=trace;" + signed[len("level=trace;"):], false, false},
+		{"Unknown field", "10.1.2.3:1234", unsigned + ";sampled=true", false, false},
+		{"Wrong key", "192.0.2.1:1234", SignDebugHeader([]byte("other"), testCorrelationID, "trace", time.Now().Add(time.Hour)), false, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := CreateCtxFromRequest(newDebugRequest(t, tc.remoteAddr, tc.header))
+
+			_, escalated := GetDebugLevel(ctx)
+			assert.Equal(t, tc.escalated, escalated)
+			assert.Equal(t, tc.escalated, log.G(ctx).GetLevel() > log.InfoLevel, "Should only escalate the context logger of a valid header")
+
+			entry, ok := log.LogrusEntry(log.G(ctx))
+			assert.True(t, ok, "Should keep the logrus backend")
+			assert.Equal(t, testCorrelationID, entry.Data["correlationID"], "Should keep the correlation fields")
+			assert.Equal(t, log.InfoLevel, log.L.GetLevel(), "Should not change the global level")
+
+			hook.Reset()
+			log.G(ctx).Debug("Debug log")
+			assert.Equal(t, tc.escalated, hook.LastEntry() != nil, "Should only write the debug logs of an escalated request")
+
+			downstream, err := http.NewRequest("GET", "example.com", nil)
+			require.NoError(t, err)
+			AddHeadersFromContext(ctx, downstream)
+
+			if tc.forwarded {
+				assert.Equal(t, tc.header, downstream.Header.Get(DebugHeader), "Should forward the signed debug header")
+			} else {
+				assert.Equal(t, "", downstream.Header.Get(DebugHeader), "Should only forward the signed debug header")
+			}
+		})
+	}
+}
+
+func TestDebugEscalationClientIP(t *testing.T) {
+	require.NoError(t, EnableDebugEscalation(DebugConfig{AllowedNetworks: []string{"10.0.0.0/8"}}))
+	defer DisableDebugEscalation()
+
+	defer SetClientIPFunc(remoteIP)
+	SetClientIPFunc(func(req *http.Request) string { return "192.0.2.1" })
+
+	header := fmt.Sprintf("level=debug;expires=%d", time.Now().Add(time.Hour).Unix())
+	ctx := CreateCtxFromRequest(newDebugRequest(t, "10.1.2.3:1234", header))
+
+	_, escalated := GetDebugLevel(ctx)
+	assert.False(t, escalated, "Should check the resolved client IP, not the address of the proxy")
+}
+
+func TestDebugEscalationDisabled(t *testing.T) {
+	signed := SignDebugHeader(testDebugKey, testCorrelationID, "trace", time.Now().Add(time.Hour))
+	ctx := CreateCtxFromRequest(newDebugRequest(t, "10.1.2.3:1234", signed))
+
+	_, escalated := GetDebugLevel(ctx)
+	assert.False(t, escalated, "Should ignore the header when the escalation is not enabled")
+}
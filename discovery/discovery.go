@@ -0,0 +1,28 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrServiceNotFound is returned when the resolver has no endpoints for the service
+var ErrServiceNotFound = errors.New("service not found")
+
+// Resolver resolves a logical service name to the addresses of its endpoints
+type Resolver interface {
+	// Resolve returns the "host:port" addresses of the service endpoints
+	Resolve(ctx context.Context, service string) ([]string, error)
+}
+
+// StaticResolver resolves services from a fixed list of endpoints
+type StaticResolver map[string][]string
+
+// Resolve returns the configured endpoints for the service
+func (r StaticResolver) Resolve(_ context.Context, service string) ([]string, error) {
+	endpoints, ok := r[service]
+	if !ok || len(endpoints) == 0 {
+		return nil, ErrServiceNotFound
+	}
+
+	return endpoints, nil
+}
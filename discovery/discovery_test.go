@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticResolver(t *testing.T) {
+	r := StaticResolver{
+		"customers": {"10.0.0.1:8082", "10.0.0.2:8082"},
+	}
+
+	endpoints, err := r.Resolve(context.Background(), "customers")
+	require.NoError(t, err, "Should resolve a known service")
+	assert.Equal(t, []string{"10.0.0.1:8082", "10.0.0.2:8082"}, endpoints)
+
+	_, err = r.Resolve(context.Background(), "orders")
+	assert.Equal(t, ErrServiceNotFound, err, "Should not resolve an unknown service")
+}
+
+func TestFileResolverReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "endpoints.json")
+	err = ioutil.WriteFile(path, []byte(`{"customers": ["10.0.0.1:8082"]}`), 0644)
+	require.NoError(t, err)
+
+	r, err := NewFileResolver(path, time.Millisecond*10)
+	require.NoError(t, err, "Should load the endpoints file")
+	defer r.Close()
+
+	endpoints, err := r.Resolve(context.Background(), "customers")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:8082"}, endpoints)
+
+	err = ioutil.WriteFile(path, []byte(`{"customers": ["10.0.0.1:8082", "10.0.0.2:8082"]}`), 0644)
+	require.NoError(t, err)
+
+	// Make sure the modification time changes on file systems with a coarse resolution
+	modTime := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		endpoints, err = r.Resolve(context.Background(), "customers")
+		if err == nil && len(endpoints) == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Equal(t, []string{"10.0.0.1:8082", "10.0.0.2:8082"}, endpoints, "Should reload the changed file")
+}
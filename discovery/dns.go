@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSRefreshInterval = time.Second * 30
+)
+
+// DNSSRVResolver resolves services using DNS SRV records, e.g. the service "customers"
+// is looked up as "_http._tcp.customers". Results, including the services not found, are cached for the refresh interval.
+type DNSSRVResolver struct {
+	service         string
+	proto           string
+	refreshInterval time.Duration
+	resolver        *net.Resolver
+
+	sync.Mutex
+	cache map[string]*srvResult
+}
+
+// srvResult holds the endpoints of a service, no endpoints when the service was not found
+type srvResult struct {
+	endpoints []string
+	expiry    time.Time
+}
+
+// NewDNSSRVResolver creates a new DNS SRV resolver for the SRV service and protocol, e.g. "http" and "tcp"
+func NewDNSSRVResolver(service, proto string, refreshInterval time.Duration) *DNSSRVResolver {
+	if refreshInterval == 0 {
+		refreshInterval = defaultDNSRefreshInterval
+	}
+
+	return &DNSSRVResolver{
+		service:         service,
+		proto:           proto,
+		refreshInterval: refreshInterval,
+		resolver:        net.DefaultResolver,
+		cache:           make(map[string]*srvResult),
+	}
+}
+
+// Resolve returns the SRV targets of the service ordered by priority
+func (r *DNSSRVResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	r.Lock()
+	cached, ok := r.cache[service]
+	r.Unlock()
+
+	if ok && time.Now().Before(cached.expiry) {
+		return cached.result()
+	}
+
+	_, records, err := r.resolver.LookupSRV(ctx, r.service, r.proto, service)
+	if err != nil {
+		if dnsErr, isDNSErr := err.(*net.DNSError); isDNSErr && dnsErr.IsNotFound {
+			r.store(service, nil)
+			return nil, ErrServiceNotFound
+		}
+
+		// Keep serving the last known endpoints if the DNS server is unavailable
+		if ok {
+			return cached.result()
+		}
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		r.store(service, nil)
+		return nil, ErrServiceNotFound
+	}
+
+	// Only use the records with the lowest priority value, the others are backups
+	var endpoints []string
+	for _, record := range records {
+		if record.Priority != records[0].Priority {
+			break
+		}
+
+		host := strings.TrimSuffix(record.Target, ".")
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+
+	r.store(service, endpoints)
+
+	return endpoints, nil
+}
+
+// store caches the endpoints of the service for the refresh interval
+func (r *DNSSRVResolver) store(service string, endpoints []string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.cache[service] = &srvResult{
+		endpoints: endpoints,
+		expiry:    time.Now().Add(r.refreshInterval),
+	}
+}
+
+func (c *srvResult) result() ([]string, error) {
+	if len(c.endpoints) == 0 {
+		return nil, ErrServiceNotFound
+	}
+
+	return c.endpoints, nil
+}
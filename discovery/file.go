@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultFileWatchInterval = time.Second * 5
+)
+
+// FileResolver resolves services from a JSON file mapping the service names to their
+// endpoints, e.g. {"customers": ["10.0.0.1:8082", "10.0.0.2:8082"]}. The file is
+// reloaded when it changes on disk.
+type FileResolver struct {
+	path string
+
+	sync.RWMutex
+	services StaticResolver
+	modTime  time.Time
+
+	done chan struct{}
+}
+
+// NewFileResolver creates a new file resolver checking the file for changes every interval
+func NewFileResolver(path string, interval time.Duration) (*FileResolver, error) {
+	if interval == 0 {
+		interval = defaultFileWatchInterval
+	}
+
+	r := &FileResolver{
+		path: path,
+		done: make(chan struct{}),
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch(interval)
+
+	return r, nil
+}
+
+// Resolve returns the endpoints of the service from the last loaded file
+func (r *FileResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.services.Resolve(ctx, service)
+}
+
+// Close stops watching the file
+func (r *FileResolver) Close() {
+	close(r.done)
+}
+
+func (r *FileResolver) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				log.G(context.TODO()).WithField("path", r.path).WithError(err).Error("Failed to reload the endpoints file")
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// reload loads the file if it was modified since the last load
+func (r *FileResolver) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	var services StaticResolver
+	if err := json.Unmarshal(b, &services); err != nil {
+		return fmt.Errorf("failed to parse endpoints file: %v", err)
+	}
+
+	r.Lock()
+	r.services = services
+	r.modTime = info.ModTime()
+	r.Unlock()
+
+	log.G(context.TODO()).WithFields(log.Fields{
+		"path":     r.path,
+		"services": len(services),
+	}).Info("Loaded the endpoints file")
+
+	return nil
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/samkreter/go-core/example/services/customers"
 	"github.com/samkreter/go-core/log"
 	"github.com/samkreter/go-core/trace"
-	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -19,16 +18,14 @@ func main() {
 	logLevel := flag.String("log-level", "info", `set the log level, e.g. "trace", debug", "info", "warn", "error"`)
 	flag.Parse()
 
-	level, err := log.ParseLevel(*logLevel)
-	if err != nil {
+	if err := log.SetLogLevel(*logLevel); err != nil {
 		log.G(context.TODO()).WithError(err).Fatal("Failed to parse log level")
 	}
 
-	logrus.SetLevel(level)
-	log.L = logrus.WithField("service", serviceName)
+	log.L = log.L.WithField("service", serviceName)
 
 	// Set up the default tracing wiht Jaeger
-	err = trace.SetupTracing(serviceName, "jaeger")
+	err := trace.SetupTracing(serviceName, "jaeger")
 	if err != nil {
 		log.G(context.TODO()).WithError(err).Fatal("Failed to initialize tracing")
 	}
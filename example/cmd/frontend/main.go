@@ -3,38 +3,36 @@ package main
 import (
 	"context"
 	"flag"
+	"strings"
 
 	"github.com/samkreter/go-core/example/services/frontend"
 	"github.com/samkreter/go-core/log"
 	"github.com/samkreter/go-core/trace"
-	"github.com/sirupsen/logrus"
 )
 
 const (
 	frontendAddr = ":8081"
-	customerAddr = "customers:8082"
 	serviceName  = "frontend"
 )
 
 func main() {
 	logLevel := flag.String("log-level", "info", `set the log level, e.g. "trace", debug", "info", "warn", "error"`)
+	customerEndpoints := flag.String("customer-endpoints", "customers:8082", "comma separated list of the customer service endpoints")
 	flag.Parse()
 
-	level, err := log.ParseLevel(*logLevel)
-	if err != nil {
+	if err := log.SetLogLevel(*logLevel); err != nil {
 		log.G(context.TODO()).WithError(err).Fatal("Failed to parse log level")
 	}
 
-	logrus.SetLevel(level)
-	log.L = logrus.WithField("service", serviceName)
+	log.L = log.L.WithField("service", serviceName)
 
-	err = trace.SetupTracing(serviceName, "jaeger")
+	err := trace.SetupTracing(serviceName, "jaeger")
 	if err != nil {
 		log.G(context.TODO()).WithError(err).Fatal("Failed to initialize tracing")
 	}
 
 	// Start the frontend service
-	f, err := frontend.NewServer(frontendAddr, customerAddr)
+	f, err := frontend.NewServer(frontendAddr, strings.Split(*customerEndpoints, ","))
 	if err != nil {
 		log.G(context.TODO()).WithError(err).Fatal("failed to create frontend server")
 	}
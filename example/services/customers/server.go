@@ -11,8 +11,10 @@ import (
 
 	otrace "go.opencensus.io/trace"
 
+	"github.com/samkreter/go-core/health"
 	"github.com/samkreter/go-core/httputil"
 	"github.com/samkreter/go-core/log"
+	"github.com/samkreter/go-core/server"
 	"github.com/samkreter/go-core/trace"
 )
 
@@ -55,7 +57,17 @@ func NewServer(addr string) (*Server, error) {
 func (s *Server) Run() {
 	router := mux.NewRouter()
 
-	router.HandleFunc("/customer", s.handleCusomter).Methods("GET")
+	checks := health.New()
+	router.Handle(health.LivenessPath, checks.LivenessHandler())
+	router.Handle(health.ReadinessPath, checks.ReadinessHandler())
+
+	router.HandleFunc("/customer", s.handleCusomter).Methods("GET").Name("GetCustomer")
+
+	operations, err := httputil.NewOperationResolver(httputil.OperationConfig{})
+	if err != nil {
+		log.G(context.TODO()).WithError(err).Fatal("Failed to create the operation resolver")
+	}
+	router.Use(operations.Middleware)
 
 	tracingRouter := httputil.SetUpHandler(router, &httputil.HandlerConfig{
 		CorrelationEnabled: true,
@@ -63,8 +75,29 @@ func (s *Server) Run() {
 		TracingEnabled:     true,
 	})
 
-	log.G(context.TODO()).WithField("address: ", s.customerAddr).Info("Starting Customer Server:")
-	log.G(context.TODO()).Fatal(http.ListenAndServe(s.customerAddr, tracingRouter))
+	srv, err := server.New(server.Config{
+		Addr:    s.customerAddr,
+		Handler: tracingRouter,
+	})
+	if err != nil {
+		log.G(context.TODO()).WithError(err).Fatal("Failed to create the server")
+	}
+	if err := checks.Register(health.Check{
+		Name:     "server",
+		Checker:  health.ReadyChecker(srv.Ready),
+		Critical: true,
+	}); err != nil {
+		log.G(context.TODO()).WithError(err).Fatal("Failed to register the health check")
+	}
+
+	srv.OnShutdown("flush traces", func(ctx context.Context) error {
+		trace.Stop()
+		return nil
+	})
+
+	if err := srv.Run(context.TODO()); err != nil {
+		log.G(context.TODO()).WithError(err).Fatal("Server failed")
+	}
 }
 
 func (s *Server) handleCusomter(w http.ResponseWriter, req *http.Request) {
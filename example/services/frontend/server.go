@@ -9,35 +9,46 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/samkreter/go-core/discovery"
 	"github.com/samkreter/go-core/httputil"
 	"github.com/samkreter/go-core/log"
+	"github.com/samkreter/go-core/server"
+	"github.com/samkreter/go-core/trace"
 )
 
 const (
 	defaultAddr = "localhost:8081"
+
+	customersService = "customers"
 )
 
 // Server holds configuration for the frontend server
 type Server struct {
 	frontendAddr string
-	customerAddr string
 	httpClient   *http.Client
 }
 
 // NewServer creates a new frontend server
-func NewServer(addr, customerAddr string) (*Server, error) {
+func NewServer(addr string, customerEndpoints []string) (*Server, error) {
 	if addr == "" {
 		addr = defaultAddr
 	}
 
-	if customerAddr == "" {
-		return nil, fmt.Errorf("Must supply custosmer Addr")
+	if len(customerEndpoints) == 0 {
+		return nil, fmt.Errorf("Must supply custosmer endpoints")
 	}
 
 	return &Server{
 		frontendAddr: addr,
-		customerAddr: customerAddr,
-		httpClient:   httputil.NewHTTPClient(true, true, true),
+		httpClient: httputil.NewHTTPClientWithConfig(&httputil.ClientConfig{
+			CorrelationEnabled: true,
+			LoggingEnabled:     true,
+			TracingEnabled:     true,
+			Resolver: discovery.StaticResolver{
+				customersService: customerEndpoints,
+			},
+			BalancingPolicy: httputil.RoundRobin,
+		}),
 	}, nil
 }
 
@@ -54,8 +65,21 @@ func (s *Server) Run() {
 		TracingEnabled:     true,
 	})
 
-	log.G(context.TODO()).WithField("address: ", s.frontendAddr).Info("Starting Frontend Server:")
-	log.G(context.TODO()).Fatal(http.ListenAndServe(s.frontendAddr, tracingRouter))
+	srv, err := server.New(server.Config{
+		Addr:    s.frontendAddr,
+		Handler: tracingRouter,
+	})
+	if err != nil {
+		log.G(context.TODO()).WithError(err).Fatal("Failed to create the server")
+	}
+	srv.OnShutdown("flush traces", func(ctx context.Context) error {
+		trace.Stop()
+		return nil
+	})
+
+	if err := srv.Run(context.TODO()); err != nil {
+		log.G(context.TODO()).WithError(err).Fatal("Server failed")
+	}
 }
 
 func (s *Server) handleCreate(w http.ResponseWriter, req *http.Request) {
@@ -82,7 +106,7 @@ func (s *Server) handleCreate(w http.ResponseWriter, req *http.Request) {
 		resp.Body.Close()
 	}
 
-	r2, err := http.NewRequest("GET", "http://"+s.customerAddr+"/customer", nil)
+	r2, err := http.NewRequest("GET", "http://"+customersService+"/customer", nil)
 	if err != nil {
 		http.Error(w, "Failed to create customer reqeust", http.StatusInternalServerError)
 		log.G(context.TODO()).WithError(err).Error("failed createing customer request")
@@ -0,0 +1,66 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/samkreter/go-core/log/hooks"
+)
+
+// HTTPChecker checks a downstream HTTP dependency, it fails when the request fails
+// or the response status code is 400 or more. Defaults to http.DefaultClient.
+func HTTPChecker(client *http.Client, url string) Checker {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return CheckerFunc(func(ctx context.Context) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// Drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("%s responded with status code %d", url, resp.StatusCode)
+		}
+
+		return nil
+	})
+}
+
+// LoggingHubChecker checks the last flush of the LoggingHub hook delivered the logs
+func LoggingHubChecker(hook *hooks.LoggingHubHook) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		lastFlush, err := hook.LastFlush()
+		if err != nil {
+			return fmt.Errorf("last flush at %s failed: %v", lastFlush.UTC().Format("2006-01-02T15:04:05Z07:00"), err)
+		}
+
+		return nil
+	})
+}
+
+// ReadyChecker fails when the ready function returns false, e.g. the Ready method of
+// the server package Server which fails readiness while shutting down
+func ReadyChecker(ready func() bool) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if !ready() {
+			return errors.New("not ready")
+		}
+
+		return nil
+	})
+}
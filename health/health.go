@@ -0,0 +1,261 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	// LivenessPath is the path of the liveness endpoint
+	LivenessPath = "/healthz"
+
+	// ReadinessPath is the path of the readiness endpoint
+	ReadinessPath = "/readyz"
+
+	defaultCheckTimeout = 5 * time.Second
+)
+
+// Status is the result of a check or of all the checks
+type Status string
+
+const (
+	// StatusPass means the checks passed
+	StatusPass Status = "pass"
+
+	// StatusWarn means a non critical check failed, the service is still healthy
+	StatusWarn Status = "warn"
+
+	// StatusFail means a critical check failed
+	StatusFail Status = "fail"
+)
+
+// Checker checks a dependency of the service, it returns an error when the dependency is unhealthy
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a function Checker
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls the function
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Check is a registered Checker
+type Check struct {
+	Name    string
+	Checker Checker
+
+	// Timeout of the check, defaults to 5s
+	Timeout time.Duration
+
+	// Critical checks fail the endpoint, the other checks only report a warning
+	Critical bool
+
+	// Interval caches the result of the check, by default the check runs for every request
+	Interval time.Duration
+
+	// Liveness checks run for the liveness endpoint, all the checks run for the readiness endpoint
+	Liveness bool
+}
+
+// CheckResult is the result of a check in the response
+type CheckResult struct {
+	Status                 Status    `json:"status"`
+	Critical               bool      `json:"critical"`
+	Error                  string    `json:"error,omitempty"`
+	DurationInMilliseconds float64   `json:"durationInMilliseconds"`
+	CheckedAt              time.Time `json:"checkedAt"`
+}
+
+// Response is the response of the health endpoints
+type Response struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// registeredCheck holds the cached result of a check
+type registeredCheck struct {
+	Check
+
+	mu     sync.Mutex
+	result CheckResult
+}
+
+// Health runs the registered checks for the liveness and readiness endpoints
+type Health struct {
+	mu     sync.RWMutex
+	checks []*registeredCheck
+}
+
+// New creates a new Health without checks
+func New() *Health {
+	return &Health{}
+}
+
+// Register adds a check
+func (h *Health) Register(check Check) error {
+	if check.Name == "" || check.Checker == nil {
+		return fmt.Errorf("check %q must have a name and a checker", check.Name)
+	}
+
+	if check.Timeout == 0 {
+		check.Timeout = defaultCheckTimeout
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, c := range h.checks {
+		if c.Name == check.Name {
+			return fmt.Errorf("check %q is already registered", check.Name)
+		}
+	}
+
+	h.checks = append(h.checks, &registeredCheck{Check: check})
+	return nil
+}
+
+// Handler serves the liveness and readiness endpoints
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(LivenessPath, h.LivenessHandler())
+	mux.Handle(ReadinessPath, h.ReadinessHandler())
+	return mux
+}
+
+// LivenessHandler runs the liveness checks
+func (h *Health) LivenessHandler() http.Handler {
+	return h.handler(true)
+}
+
+// ReadinessHandler runs all the checks
+func (h *Health) ReadinessHandler() http.Handler {
+	return h.handler(false)
+}
+
+// Run runs the checks and returns the response, only the liveness checks run when liveness is true
+func (h *Health) Run(ctx context.Context, liveness bool) Response {
+	h.mu.RLock()
+	checks := make([]*registeredCheck, 0, len(h.checks))
+	for _, c := range h.checks {
+		if !liveness || c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	resp := Response{
+		Status: StatusPass,
+		Checks: make(map[string]CheckResult, len(checks)),
+	}
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *registeredCheck) {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range checks {
+		resp.Checks[c.Name] = results[i]
+
+		switch {
+		case results[i].Status == StatusFail:
+			resp.Status = StatusFail
+		case results[i].Status == StatusWarn && resp.Status == StatusPass:
+			resp.Status = StatusWarn
+		}
+	}
+
+	return resp
+}
+
+func (h *Health) handler(liveness bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resp := h.Run(req.Context(), liveness)
+
+		statusCode := http.StatusOK
+		if resp.Status == StatusFail {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(statusCode)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.G(req.Context()).WithError(err).Error("Failed to write the health response")
+		}
+	})
+}
+
+// run runs the check or returns its cached result
+func (c *registeredCheck) run(ctx context.Context) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Interval > 0 && !c.result.CheckedAt.IsZero() && time.Now().Sub(c.result.CheckedAt) < c.Interval {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+
+	result := CheckResult{
+		Status:                 StatusPass,
+		Critical:               c.Critical,
+		DurationInMilliseconds: float64(time.Now().Sub(start)) / float64(time.Millisecond),
+		CheckedAt:              start,
+	}
+
+	if err != nil {
+		result.Status = StatusWarn
+		if c.Critical {
+			result.Status = StatusFail
+		}
+		result.Error = err.Error()
+
+		// Only log the transitions so a failing dependency does not log on every probe
+		if c.result.Status != result.Status {
+			log.G(ctx).WithError(err).WithFields(log.Fields{
+				"check":    c.Name,
+				"critical": c.Critical,
+			}).Warn("Health check failed")
+		}
+	} else if c.result.Status != "" && c.result.Status != StatusPass {
+		log.G(ctx).WithField("check", c.Name).Info("Health check recovered")
+	}
+
+	c.result = result
+	return result
+}
+
+// check runs the checker until the timeout, a checker that ignores the context fails on the timeout
+func (c *registeredCheck) check(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Checker.Check(ctx)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out after %s", c.Timeout)
+	}
+}
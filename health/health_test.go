@@ -0,0 +1,92 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getHealth(t *testing.T, handler http.Handler, path string) (int, Response) {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+	var resp Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), "Should respond with JSON")
+	return rr.Code, resp
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	h := New()
+
+	var dbUp int32
+
+	require.NoError(t, h.Register(Check{Name: "process", Checker: CheckerFunc(func(ctx context.Context) error { return nil }), Liveness: true}))
+	require.NoError(t, h.Register(Check{Name: "cache", Checker: CheckerFunc(func(ctx context.Context) error { return errors.New("timeout") })}))
+	require.NoError(t, h.Register(Check{Name: "db", Critical: true, Checker: CheckerFunc(func(ctx context.Context) error {
+		if atomic.LoadInt32(&dbUp) == 0 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})}))
+	assert.Error(t, h.Register(Check{Name: "db", Checker: CheckerFunc(func(ctx context.Context) error { return nil })}), "Should reject a duplicate name")
+
+	handler := h.Handler()
+
+	status, resp := getHealth(t, handler, LivenessPath)
+	assert.Equal(t, http.StatusOK, status, "Should only run the liveness checks")
+	assert.Equal(t, StatusPass, resp.Status)
+	assert.Equal(t, 1, len(resp.Checks))
+
+	status, resp = getHealth(t, handler, ReadinessPath)
+	assert.Equal(t, http.StatusServiceUnavailable, status, "Should fail when a critical check fails")
+	assert.Equal(t, StatusFail, resp.Status)
+	assert.Equal(t, "connection refused", resp.Checks["db"].Error)
+	assert.Equal(t, StatusWarn, resp.Checks["cache"].Status)
+
+	atomic.StoreInt32(&dbUp, 1)
+	status, resp = getHealth(t, handler, ReadinessPath)
+	assert.Equal(t, http.StatusOK, status, "Should not fail for a non critical check")
+	assert.Equal(t, StatusWarn, resp.Status)
+}
+
+func TestCheckCacheAndTimeout(t *testing.T) {
+	h := New()
+
+	var calls int32
+	require.NoError(t, h.Register(Check{Name: "cached", Interval: time.Minute, Checker: CheckerFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})}))
+	require.NoError(t, h.Register(Check{Name: "slow", Critical: true, Timeout: time.Millisecond * 10, Checker: CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})}))
+
+	h.Run(context.Background(), false)
+	resp := h.Run(context.Background(), false)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Should cache the result for the interval")
+	assert.Equal(t, StatusFail, resp.Checks["slow"].Status, "Should fail the check after the timeout")
+}
+
+func TestHTTPChecker(t *testing.T) {
+	status := int32(http.StatusOK)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer downstream.Close()
+
+	checker := HTTPChecker(nil, downstream.URL)
+	assert.NoError(t, checker.Check(context.Background()))
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	assert.Error(t, checker.Check(context.Background()), "Should fail for a server error")
+}
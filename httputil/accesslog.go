@@ -0,0 +1,399 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+)
+
+// AccessLogFormat selects the format of the incoming request logs
+type AccessLogFormat string
+
+const (
+	// AccessLogFormatDefault logs the go-core fields through the application logger
+	AccessLogFormatDefault AccessLogFormat = "default"
+
+	// AccessLogFormatCommon writes the NCSA Common Log Format
+	AccessLogFormatCommon AccessLogFormat = "common"
+
+	// AccessLogFormatCombined writes the Apache Combined Log Format
+	AccessLogFormatCombined AccessLogFormat = "combined"
+
+	// AccessLogFormatECS writes Elastic Common Schema JSON
+	AccessLogFormatECS AccessLogFormat = "ecs"
+)
+
+const (
+	// AccessLogSchemaVersion is the version of the default format fields.
+	// It changes when a field is renamed, removed or changes type.
+	AccessLogSchemaVersion = "1"
+
+	ecsVersion = "1.12.0"
+
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// AccessLogConfig holds the configuration for the AccessLogger
+type AccessLogConfig struct {
+	Format AccessLogFormat
+
+	// Writer receives the access logs. Defaults to the application logger for the
+	// default format and to stdout for the other formats.
+	Writer io.Writer
+}
+
+// AccessLogger logs the incoming requests in the configured format
+type AccessLogger struct {
+	format AccessLogFormat
+
+	// logger is only set when the default format has a dedicated writer
+	logger log.Logger
+
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// defaultAccessLogger is used by IncomingRequestLoggingMiddleware
+var defaultAccessLogger = &AccessLogger{format: AccessLogFormatDefault}
+
+// NewAccessLogger creates a new AccessLogger from the config
+func NewAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	if config.Format == "" {
+		config.Format = AccessLogFormatDefault
+	}
+
+	l := &AccessLogger{
+		format: config.Format,
+		writer: config.Writer,
+	}
+
+	switch config.Format {
+	case AccessLogFormatDefault:
+		if config.Writer != nil {
+			l.logger = log.NewJSONLogger(config.Writer)
+		}
+	case AccessLogFormatCommon, AccessLogFormatCombined, AccessLogFormatECS:
+		if l.writer == nil {
+			l.writer = os.Stdout
+		}
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", config.Format)
+	}
+
+	return l, nil
+}
+
+// accessLogEntry holds the values of a request shared by all the formats
+type accessLogEntry struct {
+	startTime       time.Time
+	method          string
+	targetURI       string
+	proto           string
+	host            string
+	scheme          string
+	clientIP        string
+	user            string
+	userAgent       string
+	referer         string
+	statusCode      int
+	bytesIn         int64
+	bytesOut        int64
+	duration        time.Duration
+	timeToFirstByte time.Duration
+	hijacked        bool
+	correlationID   string
+	activityID      string
+	extra           log.Fields
+}
+
+// durationInMilliseconds returns the duration as fractional milliseconds
+func (e *accessLogEntry) durationInMilliseconds() float64 {
+	return float64(e.duration) / float64(time.Millisecond)
+}
+
+// Middleware logs the incoming requests in the format of the logger
+func (l *AccessLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isExcludedPath(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ctx := req.Context()
+
+		entry := &accessLogEntry{
+			startTime:     time.Now(),
+			method:        req.Method,
+			targetURI:     req.URL.String(),
+			proto:         req.Proto,
+			host:          req.Host,
+			clientIP:      ClientIP(req),
+			userAgent:     req.UserAgent(),
+			referer:       req.Referer(),
+			correlationID: correlation.GetCorrelationID(ctx),
+			activityID:    correlation.GetActivityID(ctx),
+		}
+
+		// Use the client behind the trusted proxies when resolved
+		if info, ok := GetClientInfo(ctx); ok {
+			entry.host = info.Host
+			entry.scheme = info.Scheme
+		}
+
+		if user, _, ok := req.BasicAuth(); ok {
+			entry.user = user
+		}
+
+		// Allow the wrapped handlers to add their own fields to the request log
+		extra := newLogFields()
+		ctx = context.WithValue(ctx, incomingLogFieldsKey{}, extra)
+
+		// Count the body on a shallow copy, the caller's request is not modified
+		req = req.WithContext(ctx)
+		body := &countingReadCloser{ReadCloser: req.Body}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = body
+		}
+
+		w, rr := newResponseRecorder(w)
+
+		var fields log.Fields
+		if l.format == AccessLogFormatDefault {
+			fields = l.defaultFields(entry, req)
+			l.entry(ctx).WithFields(fields).Info("Incoming request Start")
+		}
+
+		defer func() {
+			// Log the panics as failures, the recovery middleware handles them
+			recovered := recover()
+
+			entry.statusCode = rr.Status()
+			if recovered != nil {
+				entry.statusCode = http.StatusInternalServerError
+			} else if entry.statusCode == 0 {
+				entry.statusCode = http.StatusOK
+			}
+			entry.bytesIn = atomic.LoadInt64(&body.n)
+			entry.bytesOut = rr.ContentLength()
+			entry.timeToFirstByte = rr.TimeToFirstByte()
+			entry.hijacked = rr.Hijacked()
+			entry.duration = time.Now().Sub(entry.startTime)
+			entry.extra = log.Fields{}
+			extra.addTo(entry.extra)
+
+			l.log(ctx, entry, fields)
+
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// entry returns the logger for the default format
+func (l *AccessLogger) entry(ctx context.Context) log.Logger {
+	if l.logger != nil {
+		return l.logger
+	}
+
+	return log.G(ctx)
+}
+
+// defaultFields returns the fields of the "Incoming request Start" log
+func (l *AccessLogger) defaultFields(entry *accessLogEntry, req *http.Request) log.Fields {
+	fields := log.Fields{
+		"schemaVersion": AccessLogSchemaVersion,
+		"httpMethod":    entry.method,
+		"targetUri":     entry.targetURI,
+		"hostName":      entry.host,
+		"clientIP":      entry.clientIP,
+		"correlationID": entry.correlationID,
+		"activityID":    entry.activityID,
+		"taskName":      "StartIncomingRequest",
+	}
+
+	if entry.scheme != "" {
+		fields["scheme"] = entry.scheme
+	}
+
+	if entry.userAgent != "" {
+		fields["userAgent"] = entry.userAgent
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	if contentType != "" {
+		fields["contentType"] = contentType
+	}
+
+	return fields
+}
+
+func (l *AccessLogger) log(ctx context.Context, entry *accessLogEntry, fields log.Fields) {
+	switch l.format {
+	case AccessLogFormatDefault:
+		fields["requestContentLength"] = entry.bytesIn
+		fields["contentLength"] = entry.bytesOut
+		fields["httpStatusCode"] = entry.statusCode
+		fields["durationInMilliseconds"] = entry.durationInMilliseconds()
+		fields["timeToFirstByteInMilliseconds"] = float64(entry.timeToFirstByte) / float64(time.Millisecond)
+		if entry.hijacked {
+			fields["hijacked"] = true
+		}
+		for k, v := range entry.extra {
+			fields[k] = v
+		}
+
+		l.entry(ctx).WithFields(fields).Info("Incoming request End")
+	case AccessLogFormatCommon:
+		l.write(ctx, []byte(formatCommonLog(entry)+"\n"))
+	case AccessLogFormatCombined:
+		l.write(ctx, []byte(formatCombinedLog(entry)+"\n"))
+	case AccessLogFormatECS:
+		line, err := json.Marshal(formatECSLog(entry))
+		if err != nil {
+			log.G(ctx).WithError(err).Error("Failed to format access log")
+			return
+		}
+		l.write(ctx, append(line, '\n'))
+	}
+}
+
+// write writes a whole line so concurrent requests do not interleave
+func (l *AccessLogger) write(ctx context.Context, line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.writer.Write(line); err != nil {
+		log.G(ctx).WithError(err).Error("Failed to write access log")
+	}
+}
+
+// formatCommonLog formats the entry as: host ident user [time] "request" status bytes
+func formatCommonLog(entry *accessLogEntry) string {
+	bytesOut := "-"
+	if entry.bytesOut > 0 {
+		bytesOut = strconv.FormatInt(entry.bytesOut, 10)
+	}
+
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		clfValue(entry.clientIP),
+		clfEscape(clfValue(entry.user)),
+		entry.startTime.Format(clfTimeFormat),
+		clfEscape(entry.method),
+		clfEscape(entry.targetURI),
+		clfEscape(entry.proto),
+		entry.statusCode,
+		bytesOut)
+}
+
+// formatCombinedLog formats the entry as the common log followed by "referer" "user agent"
+func formatCombinedLog(entry *accessLogEntry) string {
+	return fmt.Sprintf(`%s "%s" "%s"`,
+		formatCommonLog(entry),
+		clfEscape(clfValue(entry.referer)),
+		clfEscape(clfValue(entry.userAgent)))
+}
+
+// clfValue returns the "-" placeholder for empty values
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// clfEscape escapes quotes and control characters so a value cannot break the log line
+func clfEscape(value string) string {
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
+
+// formatECSLog returns the entry as Elastic Common Schema fields
+func formatECSLog(entry *accessLogEntry) map[string]interface{} {
+	request := map[string]interface{}{
+		"method": entry.method,
+		"bytes":  entry.bytesIn,
+	}
+	if entry.referer != "" {
+		request["referrer"] = entry.referer
+	}
+
+	labels := map[string]string{
+		"correlation_id": entry.correlationID,
+		"activity_id":    entry.activityID,
+	}
+	for k, v := range entry.extra {
+		labels[k] = fmt.Sprint(v)
+	}
+
+	url := map[string]interface{}{
+		"original": entry.targetURI,
+		"domain":   entry.host,
+	}
+	if entry.scheme != "" {
+		url["scheme"] = entry.scheme
+	}
+
+	doc := map[string]interface{}{
+		"@timestamp": entry.startTime.UTC().Format(time.RFC3339Nano),
+		"message":    fmt.Sprintf("%s %s %d", entry.method, entry.targetURI, entry.statusCode),
+		"ecs": map[string]interface{}{
+			"version": ecsVersion,
+		},
+		"event": map[string]interface{}{
+			"kind":     "event",
+			"category": []string{"web"},
+			"type":     []string{"access"},
+			"duration": entry.duration.Nanoseconds(),
+		},
+		"http": map[string]interface{}{
+			"version": strings.TrimPrefix(entry.proto, "HTTP/"),
+			"request": request,
+			"response": map[string]interface{}{
+				"status_code": entry.statusCode,
+				"bytes":       entry.bytesOut,
+			},
+		},
+		"url": url,
+		"client": map[string]interface{}{
+			"ip": entry.clientIP,
+		},
+		"labels": labels,
+	}
+
+	if entry.userAgent != "" {
+		doc["user_agent"] = map[string]interface{}{"original": entry.userAgent}
+	}
+
+	if entry.user != "" {
+		doc["user"] = map[string]interface{}{"name": entry.user}
+	}
+
+	return doc
+}
+
+// countingReadCloser counts the bytes read from the request body
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,157 @@
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAccessLogTestRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest("POST", "/customers?id=1", strings.NewReader(`{"name":"test"}`))
+	require.NoError(t, err, "Should not get error when creating a request")
+	AddStandardRequestHeaders(req)
+	req.RemoteAddr = "10.0.0.1:54321"
+	req.Header.Set("Referer", "http://example.com/")
+
+	return req
+}
+
+func TestAccessLoggerCombinedFormat(t *testing.T) {
+	var out bytes.Buffer
+	accessLogger, err := NewAccessLogger(AccessLogConfig{
+		Format: AccessLogFormatCombined,
+		Writer: &out,
+	})
+	require.NoError(t, err, "Should not get error creating the access logger")
+
+	handler := accessLogger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`OK`))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest(t))
+
+	line := out.String()
+	assert.True(t, strings.HasPrefix(line, "10.0.0.1 - - ["), "Should start with the client IP")
+	assert.True(t, strings.HasSuffix(line, `] "POST /customers?id=1 HTTP/1.1" 201 2 "http://example.com/" "test-user-agent"`+"\n"), "Should get the combined log line")
+}
+
+func TestAccessLoggerECSFormat(t *testing.T) {
+	var out bytes.Buffer
+	accessLogger, err := NewAccessLogger(AccessLogConfig{
+		Format: AccessLogFormatECS,
+		Writer: &out,
+	})
+	require.NoError(t, err, "Should not get error creating the access logger")
+
+	handler := CorrelationMiddleware(accessLogger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf := make([]byte, 64)
+		req.Body.Read(buf)
+		AddIncomingLogField(req, "signer", "frontend")
+		w.Write([]byte(`OK`))
+	})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest(t))
+
+	var doc struct {
+		HTTP struct {
+			Request struct {
+				Method string `json:"method"`
+				Bytes  int64  `json:"bytes"`
+			} `json:"request"`
+			Response struct {
+				StatusCode int `json:"status_code"`
+				Bytes      int `json:"bytes"`
+			} `json:"response"`
+		} `json:"http"`
+		Client struct {
+			IP string `json:"ip"`
+		} `json:"client"`
+		Event struct {
+			Duration int64 `json:"duration"`
+		} `json:"event"`
+		Labels map[string]string `json:"labels"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &doc), "Should write a json document")
+
+	assert.Equal(t, "POST", doc.HTTP.Request.Method)
+	assert.Equal(t, int64(15), doc.HTTP.Request.Bytes, "Should count the request bytes read")
+	assert.Equal(t, http.StatusOK, doc.HTTP.Response.StatusCode)
+	assert.Equal(t, 2, doc.HTTP.Response.Bytes)
+	assert.Equal(t, "10.0.0.1", doc.Client.IP)
+	assert.True(t, doc.Event.Duration > 0, "Should record the duration in nanoseconds")
+	assert.Equal(t, testCorrelationID, doc.Labels["correlation_id"])
+	assert.Equal(t, "frontend", doc.Labels["signer"], "Should add the extra fields as labels")
+}
+
+func TestAccessLoggerDefaultFormat(t *testing.T) {
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	handler := IncomingRequestLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`OK`))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest(t))
+
+	require.Equal(t, 2, len(testHook.Entries), "Should have correct number of logs")
+
+	end := testHook.Entries[1]
+	assert.Equal(t, AccessLogSchemaVersion, end.Data["schemaVersion"])
+	assert.Equal(t, "10.0.0.1", end.Data["clientIP"])
+	assert.Equal(t, "test-user-agent", end.Data["userAgent"])
+
+	_, ok := end.Data["durationInMilliseconds"].(float64)
+	assert.True(t, ok, "Should log the duration in milliseconds")
+}
+
+func TestAccessLoggerPanic(t *testing.T) {
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	handler := IncomingRequestLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		panic("handler failed")
+	}))
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"item":"a"}`))
+	body := req.Body
+
+	assert.PanicsWithValue(t, "handler failed", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}, "Should re-panic for the recovery middleware")
+	assert.Equal(t, body, req.Body, "Should not modify the caller's request")
+
+	require.Equal(t, 2, len(testHook.Entries), "Should log the end of the request")
+	assert.Equal(t, http.StatusInternalServerError, testHook.Entries[1].Data["httpStatusCode"])
+	assert.Equal(t, int64(12), testHook.Entries[1].Data["requestContentLength"])
+}
+
+func TestAccessLoggerExcludedPaths(t *testing.T) {
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	called := false
+	handler := IncomingRequestLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.True(t, called, "Should serve the excluded path")
+	assert.Equal(t, 0, len(testHook.Entries), "Should not log the excluded path")
+}
+
+func TestNewAccessLoggerUnknownFormat(t *testing.T) {
+	_, err := NewAccessLogger(AccessLogConfig{Format: "xml"})
+	assert.Error(t, err, "Should not allow unknown formats")
+}
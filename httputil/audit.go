@@ -0,0 +1,287 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+	"github.com/samkreter/go-core/log/hooks"
+)
+
+const (
+	// AuditSchemaVersion is the version of the AuditRecord schema.
+	// It changes when a field is renamed, removed or changes type.
+	AuditSchemaVersion = "1"
+
+	auditTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+	loggingHubAuditSendTimeout = 5 * time.Second
+)
+
+// Outcomes of an audited request
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeDenied  = "denied"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditRecord is the audit trail entry of a request
+type AuditRecord struct {
+	SchemaVersion string            `json:"schemaVersion"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Principal     string            `json:"principal"`
+	Tenant        string            `json:"tenant,omitempty"`
+	Method        string            `json:"method"`
+	Route         string            `json:"route"`
+	OperationName string            `json:"operationName,omitempty"`
+	ResourceIDs   map[string]string `json:"resourceIds,omitempty"`
+	StatusCode    int               `json:"statusCode"`
+	Outcome       string            `json:"outcome"`
+	ClientIP      string            `json:"clientIP"`
+	CorrelationID string            `json:"correlationID"`
+	ActivityID    string            `json:"activityID"`
+}
+
+// AuditSink writes the audit records, it must not sample or drop them
+type AuditSink interface {
+	Write(ctx context.Context, record AuditRecord) error
+}
+
+// AuditConfig holds the configuration for the Auditor
+type AuditConfig struct {
+	Sink AuditSink
+
+	// Methods are the request methods audited, defaults to POST, PUT, PATCH and DELETE
+	Methods []string
+
+	// PrincipalFunc returns the caller of the request.
+	// Defaults to the JWT subject, the request signer, the client certificate subject, then the client IP.
+	PrincipalFunc KeyExtractor
+
+	// ResourceVars are the mux route variables holding resource IDs, defaults to all the route variables
+	ResourceVars []string
+}
+
+// Auditor records the mutating requests to the audit sink
+type Auditor struct {
+	config AuditConfig
+}
+
+// NewAuditor creates a new Auditor from the config
+func NewAuditor(config AuditConfig) (*Auditor, error) {
+	if config.Sink == nil {
+		return nil, errors.New("configuration must have a Sink")
+	}
+
+	if len(config.Methods) == 0 {
+		config.Methods = []string{"POST", "PUT", "PATCH", "DELETE"}
+	}
+
+	if config.PrincipalFunc == nil {
+		config.PrincipalFunc = defaultPrincipal
+	}
+
+	return &Auditor{
+		config: config,
+	}, nil
+}
+
+// Middleware writes an audit record for each request with an audited method once it is served.
+// It is added to the router with Use so the route template and variables are available,
+// after the authentication middlewares so the principal is known.
+func (a *Auditor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !containsString(a.config.Methods, req.Method) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		start := time.Now()
+		w, rr := newResponseRecorder(w)
+
+		defer func() {
+			// Audit the panics as failures, the recovery middleware handles them
+			if recovered := recover(); recovered != nil {
+				a.write(req, start, http.StatusInternalServerError)
+				panic(recovered)
+			}
+
+			a.write(req, start, rr.Status())
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (a *Auditor) write(req *http.Request, start time.Time, status int) {
+	ctx := req.Context()
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	record := AuditRecord{
+		SchemaVersion: AuditSchemaVersion,
+		Timestamp:     start.UTC(),
+		Principal:     a.config.PrincipalFunc(req),
+		Tenant:        GetTenant(ctx),
+		Method:        req.Method,
+		Route:         routeTemplate(req),
+		OperationName: GetOperationName(ctx),
+		StatusCode:    status,
+		Outcome:       auditOutcome(status),
+		ClientIP:      ClientIP(req),
+		CorrelationID: correlation.GetCorrelationID(ctx),
+		ActivityID:    correlation.GetActivityID(ctx),
+	}
+
+	vars := mux.Vars(req)
+	for name, value := range vars {
+		if len(a.config.ResourceVars) == 0 || containsString(a.config.ResourceVars, name) {
+			if record.ResourceIDs == nil {
+				record.ResourceIDs = make(map[string]string)
+			}
+			record.ResourceIDs[name] = value
+		}
+	}
+
+	if err := a.config.Sink.Write(ctx, record); err != nil {
+		// Keep the record in the application logs so it is not lost
+		b, _ := json.Marshal(record)
+		log.G(ctx).WithError(err).WithField("auditRecord", string(b)).Error("Failed to write the audit record")
+	}
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return AuditOutcomeDenied
+	case status >= http.StatusBadRequest:
+		return AuditOutcomeFailure
+	default:
+		return AuditOutcomeSuccess
+	}
+}
+
+// WriterAuditSink writes the audit records as JSON lines
+type WriterAuditSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewWriterAuditSink creates a new WriterAuditSink
+func NewWriterAuditSink(writer io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{writer: writer}
+}
+
+// Write writes the record as a JSON line
+func (s *WriterAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.writer.Write(append(b, '\n'))
+	return err
+}
+
+// FileAuditSink appends the audit records as JSON lines to a file and syncs every record to disk
+type FileAuditSink struct {
+	file *os.File
+	sink *WriterAuditSink
+}
+
+// NewFileAuditSink opens the file for appending, creating it if needed
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{
+		file: file,
+		sink: NewWriterAuditSink(file),
+	}, nil
+}
+
+// Write appends the record and syncs the file
+func (s *FileAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	if err := s.sink.Write(ctx, record); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// Close closes the file
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// LoggingHubAuditSink sends the audit records to the logging hub, regardless of the levels of the hook
+type LoggingHubAuditSink struct {
+	hook *hooks.LoggingHubHook
+}
+
+// NewLoggingHubAuditSink creates a new LoggingHubAuditSink.
+// The hook must have RequeueFailed set so the records of a failed flush are sent again instead of dropped,
+// use a hook with its own senders so the audit trail is separate from the application logs.
+func NewLoggingHubAuditSink(hook *hooks.LoggingHubHook) (*LoggingHubAuditSink, error) {
+	if hook == nil {
+		return nil, errors.New("logging hub hook can not be nil")
+	}
+
+	if !hook.RequeueFailed() {
+		return nil, errors.New("logging hub hook must have RequeueFailed set for audit records")
+	}
+
+	return &LoggingHubAuditSink{hook: hook}, nil
+}
+
+// Write queues the record on the hook, it fails when the queue stays full for the send timeout
+func (s *LoggingHubAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	fields := map[string]string{
+		"schemaVersion": record.SchemaVersion,
+		"principal":     record.Principal,
+		"tenant":        record.Tenant,
+		"method":        record.Method,
+		"route":         record.Route,
+		"operationName": record.OperationName,
+		"statusCode":    fmt.Sprint(record.StatusCode),
+		"outcome":       record.Outcome,
+		"clientIP":      record.ClientIP,
+		"correlationID": record.CorrelationID,
+		"activityID":    record.ActivityID,
+	}
+
+	if len(record.ResourceIDs) > 0 {
+		b, err := json.Marshal(record.ResourceIDs)
+		if err != nil {
+			return err
+		}
+		fields["resourceIds"] = string(b)
+	}
+
+	// The request context is not used so a client disconnecting does not drop the record
+	ctx, cancel := context.WithTimeout(context.Background(), loggingHubAuditSendTimeout)
+	defer cancel()
+
+	return s.hook.Send(ctx, &hooks.LoggingHubEntry{
+		Log:    fmt.Sprintf("Audit %s %s %s", record.Method, record.Route, record.Outcome),
+		Time:   record.Timestamp.Format(auditTimeFormat),
+		Level:  log.InfoLevel.String(),
+		Fields: fields,
+	})
+}
@@ -0,0 +1,142 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samkreter/go-core/log/hooks"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	return errors.New("disk full")
+}
+
+func TestAuditor(t *testing.T) {
+	var out bytes.Buffer
+	auditor, err := NewAuditor(AuditConfig{Sink: NewWriterAuditSink(&out)})
+	require.NoError(t, err, "Should not get error creating the auditor")
+
+	router := mux.NewRouter()
+	router.Use(auditor.Middleware)
+	router.HandleFunc("/tenants/{tenantID}/orders/{orderID}", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}).Methods("GET", "PUT", "DELETE")
+
+	handler := CorrelationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(WithClaims(req.Context(), &Claims{Subject: "user-1"})))
+	}))
+
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		req, err := http.NewRequest(method, "/tenants/t1/orders/o1", nil)
+		require.NoError(t, err, "Should not get error when creating a request")
+		AddStandardRequestHeaders(req)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	decoder := json.NewDecoder(&out)
+	var records []AuditRecord
+	for decoder.More() {
+		var record AuditRecord
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+
+	require.Equal(t, 2, len(records), "Should only audit the mutating methods")
+
+	assert.Equal(t, AuditSchemaVersion, records[0].SchemaVersion)
+	assert.Equal(t, "user-1", records[0].Principal)
+	assert.Equal(t, "PUT", records[0].Method)
+	assert.Equal(t, "/tenants/{tenantID}/orders/{orderID}", records[0].Route)
+	assert.Equal(t, map[string]string{"tenantID": "t1", "orderID": "o1"}, records[0].ResourceIDs)
+	assert.Equal(t, AuditOutcomeSuccess, records[0].Outcome)
+	assert.Equal(t, testCorrelationID, records[0].CorrelationID)
+	assert.False(t, records[0].Timestamp.IsZero())
+
+	assert.Equal(t, http.StatusForbidden, records[1].StatusCode)
+	assert.Equal(t, AuditOutcomeDenied, records[1].Outcome)
+}
+
+func TestAuditorSinkFailure(t *testing.T) {
+	auditor, err := NewAuditor(AuditConfig{Sink: failingAuditSink{}})
+	require.NoError(t, err, "Should not get error creating the auditor")
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	handler := auditor.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/orders", nil))
+
+	require.NotNil(t, testHook.LastEntry())
+	assert.Equal(t, "Failed to write the audit record", testHook.LastEntry().Message)
+	assert.Contains(t, testHook.LastEntry().Data["auditRecord"], `"route":"/orders"`, "Should keep the record in the application logs")
+}
+
+func TestLoggingHubAuditSinkRequeueFailed(t *testing.T) {
+	hook, err := hooks.NewWithConfig(hooks.Config{
+		LoggingHubURL: "http://127.0.0.1:1",
+		Senders:       []string{"audit"},
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	_, err = NewLoggingHubAuditSink(hook)
+	assert.Error(t, err, "Should not accept a hook that drops the records of a failed flush")
+}
+
+func TestLoggingHubAuditSinkFailedFlush(t *testing.T) {
+	attempts := 0
+	received := make(chan []*hooks.LoggingHubEntry, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var body hooks.LoggingHubEntriesReq
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		received <- body.Entries
+	}))
+	defer server.Close()
+
+	// Every record is flushed as soon as the hook receives it
+	hook, err := hooks.NewWithConfig(hooks.Config{
+		LoggingHubURL:    server.URL,
+		Senders:          []string{"audit"},
+		FlushInterval:    time.Hour,
+		BatchSizeInLines: 1,
+		RequeueFailed:    true,
+	})
+	require.NoError(t, err)
+
+	sink, err := NewLoggingHubAuditSink(hook)
+	require.NoError(t, err, "Should not get error creating the sink")
+
+	require.NoError(t, sink.Write(context.Background(), AuditRecord{Method: "POST", Route: "/orders", Outcome: "success"}))
+	require.NoError(t, sink.Write(context.Background(), AuditRecord{Method: "DELETE", Route: "/orders", Outcome: "success"}))
+
+	select {
+	case entries := <-received:
+		if assert.Len(t, entries, 2, "Should send the records of the failed flush with the next flush") {
+			assert.Equal(t, "Audit POST /orders success", entries[0].Log)
+			assert.Equal(t, "Audit DELETE /orders success", entries[1].Log)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should send the records after the failed flush")
+	}
+}
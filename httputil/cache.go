@@ -0,0 +1,435 @@
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	nethttputil "net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	cacheStatusField = "cacheStatus"
+
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheRevalidated = "revalidated"
+
+	// Internal headers used to store the cache metadata with the response,
+	// they are removed before the response is returned.
+	cacheResponseTimeHeader = "X-Go-Core-Cache-Response-Time"
+	cacheVaryHeaderPrefix   = "X-Go-Core-Cache-Vary-"
+
+	defaultCacheMaxBodyBytes = 1 << 20
+)
+
+// cacheableStatusCodes are the status codes which are cacheable by default
+var cacheableStatusCodes = map[int]struct{}{
+	http.StatusOK:                   {},
+	http.StatusNonAuthoritativeInfo: {},
+	http.StatusMultipleChoices:      {},
+	http.StatusMovedPermanently:     {},
+	http.StatusNotFound:             {},
+	http.StatusGone:                 {},
+	http.StatusPermanentRedirect:    {},
+}
+
+// CacheStore stores the serialized responses for the CachingTransport
+type CacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryCacheStore is an in-memory CacheStore which evicts the least recently used responses
+type MemoryCacheStore struct {
+	sync.Mutex
+	lru *simplelru.LRU
+}
+
+// NewMemoryCacheStore creates a new MemoryCacheStore holding at most size responses
+func NewMemoryCacheStore(size int) (*MemoryCacheStore, error) {
+	lru, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryCacheStore{
+		lru: lru,
+	}, nil
+}
+
+// Get returns the response stored for the key
+func (s *MemoryCacheStore) Get(key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return value.([]byte), true
+}
+
+// Set stores the response for the key
+func (s *MemoryCacheStore) Set(key string, value []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.lru.Add(key, value)
+}
+
+// Delete removes the response stored for the key
+func (s *MemoryCacheStore) Delete(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.lru.Remove(key)
+}
+
+// CachingTransport implements http.RoundTripper.
+// When set as Transport of http.Client, it caches responses following the
+// RFC 7234 rules for a shared cache, the responses to requests with an Authorization header
+// are only stored when they allow it, so a response is never replayed to another credential.
+type CachingTransport struct {
+	Transport http.RoundTripper
+	Store     CacheStore
+
+	// MaxBodyBytes is the size of the largest body stored, defaults to 1MB
+	MaxBodyBytes int64
+}
+
+// RoundTrip implements http.RoundTripper and serves the client requests from the cache when possible
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+
+	if req.Method != http.MethodGet {
+		resp, err := t.transport().RoundTrip(req)
+
+		// Unsafe methods invalidate the stored response
+		if err == nil && req.Method != http.MethodHead && resp.StatusCode < 400 {
+			t.Store.Delete(key)
+		}
+
+		return resp, err
+	}
+
+	reqCacheControl := parseCacheControl(req.Header)
+	if _, ok := reqCacheControl["no-store"]; ok {
+		return t.transport().RoundTrip(req)
+	}
+
+	cached, responseTime := t.load(key, req)
+	if cached != nil && isFresh(req, reqCacheControl, cached, responseTime) {
+		AddOutgoingLogField(req, cacheStatusField, cacheHit)
+		return cached, nil
+	}
+
+	outReq := req
+	if cached != nil {
+		outReq = addConditionalHeaders(req, cached)
+	}
+
+	resp, err := t.transport().RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+
+		mergeHeaders(cached.Header, resp.Header)
+		cached.Header.Set(cacheResponseTimeHeader, time.Now().UTC().Format(time.RFC3339Nano))
+		t.store(key, req, cached)
+
+		revalidated, _ := t.load(key, req)
+		AddOutgoingLogField(req, cacheStatusField, cacheRevalidated)
+		return revalidated, nil
+	}
+
+	AddOutgoingLogField(req, cacheStatusField, cacheMiss)
+
+	if !isCacheable(req, resp) || resp.ContentLength > t.maxBodyBytes() {
+		if cached != nil {
+			t.Store.Delete(key)
+		}
+		return resp, nil
+	}
+
+	// Store the response once the caller has read the full body
+	receivedAt := time.Now().UTC()
+	resp.Body = &cachingReadCloser{
+		ReadCloser: resp.Body,
+		maxBytes:   t.maxBodyBytes(),
+		onEOF: func(body []byte) {
+			stored := *resp
+			stored.Header = resp.Header.Clone()
+			stored.Header.Set(cacheResponseTimeHeader, receivedAt.Format(time.RFC3339Nano))
+			stored.Body = ioutil.NopCloser(bytes.NewReader(body))
+			t.store(key, req, &stored)
+		},
+	}
+
+	return resp, nil
+}
+
+func (t *CachingTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+
+	return http.DefaultTransport
+}
+
+func (t *CachingTransport) maxBodyBytes() int64 {
+	if t.MaxBodyBytes > 0 {
+		return t.MaxBodyBytes
+	}
+
+	return defaultCacheMaxBodyBytes
+}
+
+// store serializes the response together with the request header values it varies on
+func (t *CachingTransport) store(key string, req *http.Request, resp *http.Response) {
+	for _, name := range varyHeaders(resp.Header) {
+		resp.Header.Set(cacheVaryHeaderPrefix+name, req.Header.Get(name))
+	}
+
+	b, err := nethttputil.DumpResponse(resp, true)
+	if err != nil {
+		return
+	}
+
+	t.Store.Set(key, b)
+}
+
+// load returns the stored response for the key and the time it was received, if it
+// matches the varied request headers. The internal cache headers are removed.
+func (t *CachingTransport) load(key string, req *http.Request) (*http.Response, time.Time) {
+	b, ok := t.Store.Get(key)
+	if !ok {
+		return nil, time.Time{}
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		t.Store.Delete(key)
+		return nil, time.Time{}
+	}
+
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" || resp.Header.Get(cacheVaryHeaderPrefix+name) != req.Header.Get(name) {
+			return nil, time.Time{}
+		}
+		resp.Header.Del(cacheVaryHeaderPrefix + name)
+	}
+
+	responseTime, err := time.Parse(time.RFC3339Nano, resp.Header.Get(cacheResponseTimeHeader))
+	if err != nil {
+		t.Store.Delete(key)
+		return nil, time.Time{}
+	}
+	resp.Header.Del(cacheResponseTimeHeader)
+
+	return resp, responseTime
+}
+
+// isFresh returns true if the stored response can be served without revalidation
+func isFresh(req *http.Request, reqCacheControl map[string]string, resp *http.Response, responseTime time.Time) bool {
+	if _, ok := reqCacheControl["no-cache"]; ok {
+		return false
+	}
+
+	if len(reqCacheControl) == 0 && req.Header.Get("Pragma") == "no-cache" {
+		return false
+	}
+
+	respCacheControl := parseCacheControl(resp.Header)
+	if _, ok := respCacheControl["no-cache"]; ok {
+		return false
+	}
+
+	age := time.Since(responseTime)
+	if ageSeconds, err := strconv.Atoi(resp.Header.Get("Age")); err == nil {
+		age += time.Duration(ageSeconds) * time.Second
+	}
+
+	lifetime := freshnessLifetime(resp.Header, respCacheControl)
+	if maxAge, ok := parseSeconds(reqCacheControl, "max-age"); ok && maxAge < lifetime {
+		lifetime = maxAge
+	}
+
+	return age < lifetime
+}
+
+// freshnessLifetime returns how long the response is fresh for from max-age or Expires
+func freshnessLifetime(header http.Header, cacheControl map[string]string) time.Duration {
+	if maxAge, ok := parseSeconds(cacheControl, "max-age"); ok {
+		return maxAge
+	}
+
+	expires, err := http.ParseTime(header.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+
+	return expires.Sub(date)
+}
+
+// isCacheable returns true if the response may be stored
+func isCacheable(req *http.Request, resp *http.Response) bool {
+	if _, ok := cacheableStatusCodes[resp.StatusCode]; !ok {
+		return false
+	}
+
+	cacheControl := parseCacheControl(resp.Header)
+	if _, ok := cacheControl["no-store"]; ok {
+		return false
+	}
+
+	// RFC 7234 section 3.2, the responses to authorized requests are only stored when explicitly allowed
+	if req.Header.Get("Authorization") != "" {
+		_, public := cacheControl["public"]
+		_, sMaxAge := cacheControl["s-maxage"]
+		_, mustRevalidate := cacheControl["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	// Without freshness information or validators the response could never be reused
+	_, hasMaxAge := cacheControl["max-age"]
+	return hasMaxAge ||
+		resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// addConditionalHeaders returns a copy of the request validating the stored response
+func addConditionalHeaders(req *http.Request, cached *http.Response) *http.Request {
+	etag := cached.Header.Get("ETag")
+	lastModified := cached.Header.Get("Last-Modified")
+
+	if etag == "" && lastModified == "" {
+		return req
+	}
+
+	outReq := req.Clone(req.Context())
+
+	if etag != "" && outReq.Header.Get("If-None-Match") == "" {
+		outReq.Header.Set("If-None-Match", etag)
+	}
+
+	if lastModified != "" && outReq.Header.Get("If-Modified-Since") == "" {
+		outReq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	return outReq
+}
+
+// mergeHeaders updates the stored headers with the ones from a 304 response
+func mergeHeaders(stored, updated http.Header) {
+	for name, values := range updated {
+		if name == "Content-Length" {
+			continue
+		}
+
+		stored[name] = values
+	}
+}
+
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return names
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	cacheControl := map[string]string{}
+
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+
+			if i := strings.Index(directive, "="); i >= 0 {
+				name := strings.ToLower(strings.TrimSpace(directive[:i]))
+				cacheControl[name] = strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			} else {
+				cacheControl[strings.ToLower(directive)] = ""
+			}
+		}
+	}
+
+	return cacheControl
+}
+
+func parseSeconds(cacheControl map[string]string, directive string) (time.Duration, bool) {
+	value, ok := cacheControl[directive]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// cachingReadCloser buffers the body and calls onEOF with the full body once it is read.
+// It stops buffering without calling onEOF once the body is larger than maxBytes.
+type cachingReadCloser struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	maxBytes int64
+	onEOF    func([]byte)
+}
+
+func (r *cachingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	if r.onEOF != nil {
+		if int64(r.buf.Len()+n) > r.maxBytes {
+			r.onEOF = nil
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && r.onEOF != nil {
+		r.onEOF(r.buf.Bytes())
+		r.onEOF = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,188 @@
+package httputil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCachingClient(t *testing.T) *http.Client {
+	store, err := NewMemoryCacheStore(10)
+	require.NoError(t, err, "Should not get error creating the cache store")
+
+	return NewHTTPClientWithConfig(&ClientConfig{
+		LoggingEnabled: true,
+		CacheStore:     store,
+	})
+}
+
+func doGet(t *testing.T, c *http.Client, url string, headers map[string]string) string {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err, "Should not get error creating request")
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.Do(req)
+	require.NoError(t, err, "Should not get error for server response")
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "Should not get error reading the body")
+	assert.Equal(t, 200, resp.StatusCode, "Should get OK status code.")
+
+	return string(b)
+}
+
+func TestCachingTransportMaxAge(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Write([]byte(`OK`))
+	}))
+
+	defer server.Close()
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := newTestCachingClient(t)
+
+	assert.Equal(t, "OK", doGet(t, c, server.URL, nil))
+	assert.Equal(t, "OK", doGet(t, c, server.URL, nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "Second request should be served from the cache")
+
+	assert.Equal(t, cacheMiss, testHook.Entries[1].Data[cacheStatusField], "Should log the cache miss")
+	assert.Equal(t, cacheHit, testHook.Entries[3].Data[cacheStatusField], "Should log the cache hit")
+
+	doGet(t, c, server.URL, map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "no-cache request should go to the server")
+}
+
+func TestCachingTransportNoStore(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rw.Header().Set("Cache-Control", "no-store, max-age=60")
+		rw.Write([]byte(`OK`))
+	}))
+
+	defer server.Close()
+
+	c := newTestCachingClient(t)
+
+	doGet(t, c, server.URL, nil)
+	doGet(t, c, server.URL, nil)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "no-store responses should not be cached")
+}
+
+func TestCachingTransportRevalidation(t *testing.T) {
+	var requests, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rw.Header().Set("ETag", `"v1"`)
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Write([]byte(`OK`))
+	}))
+
+	defer server.Close()
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := newTestCachingClient(t)
+
+	assert.Equal(t, "OK", doGet(t, c, server.URL, nil))
+	assert.Equal(t, "OK", doGet(t, c, server.URL, nil), "Should return the cached body on 304")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "Should revalidate the stale response")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified), "Should send If-None-Match")
+
+	assert.Equal(t, cacheRevalidated, testHook.Entries[3].Data[cacheStatusField], "Should log the revalidation")
+}
+
+func TestCachingTransportVary(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Header().Set("Vary", "Accept-Language")
+		rw.Write([]byte(req.Header.Get("Accept-Language")))
+	}))
+
+	defer server.Close()
+
+	c := newTestCachingClient(t)
+
+	assert.Equal(t, "en", doGet(t, c, server.URL, map[string]string{"Accept-Language": "en"}))
+	assert.Equal(t, "en", doGet(t, c, server.URL, map[string]string{"Accept-Language": "en"}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "Matching varied header should be served from the cache")
+
+	assert.Equal(t, "fr", doGet(t, c, server.URL, map[string]string{"Accept-Language": "fr"}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "Different varied header should not be served from the cache")
+}
+
+func TestCachingTransportAuthorization(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if req.URL.Path == "/public" {
+			rw.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			rw.Header().Set("Cache-Control", "max-age=60")
+		}
+		rw.Write([]byte(req.Header.Get("Authorization")))
+	}))
+
+	defer server.Close()
+
+	c := newTestCachingClient(t)
+
+	assert.Equal(t, "Bearer alice", doGet(t, c, server.URL+"/private", map[string]string{"Authorization": "Bearer alice"}))
+	assert.Equal(t, "Bearer bob", doGet(t, c, server.URL+"/private", map[string]string{"Authorization": "Bearer bob"}),
+		"Should not replay the response of an authorized request to another credential")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	assert.Equal(t, "Bearer alice", doGet(t, c, server.URL+"/public", map[string]string{"Authorization": "Bearer alice"}))
+	assert.Equal(t, "Bearer alice", doGet(t, c, server.URL+"/public", map[string]string{"Authorization": "Bearer bob"}),
+		"Should store the public responses of authorized requests")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestCachingTransportMaxBodyBytes(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.(http.Flusher).Flush()
+		rw.Write([]byte(req.URL.Path))
+	}))
+
+	defer server.Close()
+
+	store, err := NewMemoryCacheStore(10)
+	require.NoError(t, err)
+	c := &http.Client{Transport: &CachingTransport{Store: store, MaxBodyBytes: 6}}
+
+	assert.Equal(t, "/small", doGet(t, c, server.URL+"/small", nil))
+	assert.Equal(t, "/small", doGet(t, c, server.URL+"/small", nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	assert.Equal(t, "/too-large", doGet(t, c, server.URL+"/too-large", nil))
+	assert.Equal(t, "/too-large", doGet(t, c, server.URL+"/too-large", nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "Should not store the bodies larger than the limit")
+}
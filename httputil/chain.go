@@ -0,0 +1,253 @@
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Names of the httputil middlewares in a Chain
+const (
+	CorrelationMiddlewareName = "correlation"
+	LoggingMiddlewareName     = "logging"
+	TracingMiddlewareName     = "tracing"
+	RecoveryMiddlewareName    = "recovery"
+)
+
+// Middleware wraps a handler
+type Middleware func(http.Handler) http.Handler
+
+// ChainMiddleware is a named middleware of a Chain
+type ChainMiddleware struct {
+	Name       string
+	Middleware Middleware
+
+	// Requires are the middlewares that must be in the chain and run before this one
+	Requires []string
+
+	// After are the middlewares that must run before this one when they are in the chain
+	After []string
+}
+
+// The httputil middlewares with their ordering dependencies
+var (
+	// CorrelationChainMiddleware adds the correlation information used by the other middlewares
+	CorrelationChainMiddleware = ChainMiddleware{
+		Name:       CorrelationMiddlewareName,
+		Middleware: CorrelationMiddleware,
+		After:      []string{ClientIPMiddlewareName},
+	}
+
+	// LoggingChainMiddleware logs the incoming requests with their correlation information
+	LoggingChainMiddleware = ChainMiddleware{
+		Name:       LoggingMiddlewareName,
+		Middleware: IncomingRequestLoggingMiddleware,
+		Requires:   []string{CorrelationMiddlewareName},
+		After:      []string{ClientIPMiddlewareName},
+	}
+
+	// TracingChainMiddleware starts the server span
+	TracingChainMiddleware = ChainMiddleware{
+		Name:       TracingMiddlewareName,
+		Middleware: TracingMiddleware,
+		After:      []string{ClientIPMiddlewareName, CorrelationMiddlewareName, LoggingMiddlewareName},
+	}
+
+	// PeerIdentityChainMiddleware adds the identity of the client certificate, it runs after logging so
+	// the identity is in the incoming request log
+	PeerIdentityChainMiddleware = ChainMiddleware{
+		Name:       PeerIdentityMiddlewareName,
+		Middleware: PeerIdentityMiddleware,
+		After:      []string{CorrelationMiddlewareName, LoggingMiddlewareName},
+	}
+
+	// RecoveryChainMiddleware recovers the panics, it runs after logging and tracing so
+	// the panic is logged with the span and the recovered response is logged
+	RecoveryChainMiddleware = ChainMiddleware{
+		Name:       RecoveryMiddlewareName,
+		Middleware: RecoveryMiddleware,
+		After:      []string{CorrelationMiddlewareName, LoggingMiddlewareName, TracingMiddlewareName},
+	}
+)
+
+// Chain is an ordered list of named middlewares, the first middleware runs first.
+// The methods return a new Chain so a base chain can be shared and overridden per route or subrouter.
+type Chain struct {
+	middlewares []ChainMiddleware
+
+	// provided are the middlewares already run by an outer handler, e.g. the parent router
+	provided []string
+}
+
+// NewChain creates a new Chain with the middlewares
+func NewChain(middlewares ...ChainMiddleware) *Chain {
+	return &Chain{
+		middlewares: append([]ChainMiddleware{}, middlewares...),
+	}
+}
+
+// With returns a new Chain with the middlewares added at the end
+func (c *Chain) With(middlewares ...ChainMiddleware) *Chain {
+	chain := c.copy()
+	chain.middlewares = append(chain.middlewares, middlewares...)
+	return chain
+}
+
+// Without returns a new Chain without the named middlewares
+func (c *Chain) Without(names ...string) *Chain {
+	chain := c.copy()
+	chain.middlewares = chain.middlewares[:0]
+
+	for _, m := range c.middlewares {
+		if !containsString(names, m.Name) {
+			chain.middlewares = append(chain.middlewares, m)
+		}
+	}
+
+	return chain
+}
+
+// Replace returns a new Chain with the middleware of the same name replaced
+func (c *Chain) Replace(middleware ChainMiddleware) *Chain {
+	chain := c.copy()
+
+	for i, m := range chain.middlewares {
+		if m.Name == middleware.Name {
+			chain.middlewares[i] = middleware
+		}
+	}
+
+	return chain
+}
+
+// Provided returns a new Chain that treats the named middlewares as already run,
+// e.g. by the parent router of a subrouter
+func (c *Chain) Provided(names ...string) *Chain {
+	chain := c.copy()
+	chain.provided = append(chain.provided, names...)
+	return chain
+}
+
+// Names returns the names of the middlewares in order
+func (c *Chain) Names() []string {
+	names := make([]string, 0, len(c.middlewares))
+	for _, m := range c.middlewares {
+		names = append(names, m.Name)
+	}
+
+	return names
+}
+
+// Validate checks the names are unique and the ordering dependencies are met
+func (c *Chain) Validate() error {
+	seen := append([]string{}, c.provided...)
+	names := c.Names()
+
+	for _, m := range c.middlewares {
+		if m.Name == "" || m.Middleware == nil {
+			return fmt.Errorf("middleware %q must have a name and a middleware", m.Name)
+		}
+
+		if containsString(seen, m.Name) {
+			return fmt.Errorf("middleware %q is in the chain more than once", m.Name)
+		}
+
+		for _, required := range m.Requires {
+			if !containsString(seen, required) {
+				return fmt.Errorf("middleware %q requires %q to run before it", m.Name, required)
+			}
+		}
+
+		for _, after := range m.After {
+			if containsString(names, after) && !containsString(seen, after) {
+				return fmt.Errorf("middleware %q must run after %q", m.Name, after)
+			}
+		}
+
+		seen = append(seen, m.Name)
+	}
+
+	return nil
+}
+
+// Then validates the chain and wraps the handler with its middlewares
+func (c *Chain) Then(handler http.Handler) (http.Handler, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.then(handler), nil
+}
+
+// ChainOverride replaces the chain for the routes of a subrouter
+type ChainOverride struct {
+	Router *mux.Router
+	Chain  *Chain
+}
+
+// For returns a ChainOverride using the chain for the routes of the subrouter and its own subrouters,
+// e.g. base.Without(JWTMiddlewareName).For(public) to skip the authentication on a public subtree
+func (c *Chain) For(router *mux.Router) ChainOverride {
+	return ChainOverride{Router: router, Chain: c}
+}
+
+// Apply validates the chains and wraps the handlers of the routes of the router and its subrouters,
+// the middlewares only run for matched routes. The routes of an overridden subrouter only run the override chain.
+// Call it once all the routes are added, the routes added afterwards are not wrapped.
+func (c *Chain) Apply(router *mux.Router, overrides ...ChainOverride) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	chains := make(map[*mux.Router]*Chain, len(overrides))
+	for _, override := range overrides {
+		if err := override.Chain.Validate(); err != nil {
+			return err
+		}
+		chains[override.Router] = override.Chain
+	}
+
+	// routers holds the router of each visited route to find the chains of the parent routers
+	routers := make(map[*mux.Route]*mux.Router)
+
+	return router.Walk(func(route *mux.Route, current *mux.Router, ancestors []*mux.Route) error {
+		routers[route] = current
+
+		handler := route.GetHandler()
+		if handler == nil {
+			return nil
+		}
+
+		chain := c
+		if override, ok := chains[current]; ok {
+			chain = override
+		} else {
+			for i := len(ancestors) - 1; i >= 0; i-- {
+				if override, ok := chains[routers[ancestors[i]]]; ok {
+					chain = override
+					break
+				}
+			}
+		}
+
+		route.Handler(chain.then(handler))
+		return nil
+	})
+}
+
+// then wraps the handler without validating the chain
+func (c *Chain) then(handler http.Handler) http.Handler {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i].Middleware(handler)
+	}
+
+	return handler
+}
+
+func (c *Chain) copy() *Chain {
+	return &Chain{
+		middlewares: append([]ChainMiddleware{}, c.middlewares...),
+		provided:    append([]string{}, c.provided...),
+	}
+}
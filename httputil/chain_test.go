@@ -0,0 +1,109 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderMiddleware records the order the middlewares ran in
+func orderMiddleware(name string, order *[]string) ChainMiddleware {
+	return ChainMiddleware{
+		Name: name,
+		Middleware: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				*order = append(*order, name)
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	chain := NewChain(orderMiddleware("first", &order), orderMiddleware("second", &order)).
+		With(orderMiddleware("third", &order))
+
+	handler, err := chain.Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	require.NoError(t, err, "Should not get error building the chain")
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"first", "second", "third"}, order, "Should run the middlewares in order")
+
+	order = nil
+	handler, err = chain.Without("second").Then(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	require.NoError(t, err, "Should not get error building the chain")
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"first", "third"}, order, "Should remove the middleware")
+	assert.Equal(t, []string{"first", "second", "third"}, chain.Names(), "Should not change the base chain")
+}
+
+func TestChainValidate(t *testing.T) {
+	tt := []struct {
+		name  string
+		chain *Chain
+		valid bool
+	}{
+		{"Default order", NewChain(CorrelationChainMiddleware, LoggingChainMiddleware, TracingChainMiddleware, RecoveryChainMiddleware), true},
+		{"Logging without correlation", NewChain(LoggingChainMiddleware), false},
+		{"Logging before correlation", NewChain(LoggingChainMiddleware, CorrelationChainMiddleware), false},
+		{"Logging with provided correlation", NewChain(LoggingChainMiddleware).Provided(CorrelationMiddlewareName), true},
+		{"Recovery before tracing", NewChain(RecoveryChainMiddleware, TracingChainMiddleware), false},
+		{"Recovery without tracing", NewChain(RecoveryChainMiddleware), true},
+		{"Duplicate", NewChain(CorrelationChainMiddleware, CorrelationChainMiddleware), false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.chain.Validate()
+			assert.Equal(t, tc.valid, err == nil, "Should validate the chain: %v", err)
+		})
+	}
+}
+
+func TestChainSubrouterOverride(t *testing.T) {
+	var order []string
+	base := NewChain(orderMiddleware("auth", &order), orderMiddleware("audit", &order))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/orders", func(w http.ResponseWriter, req *http.Request) {})
+
+	public := router.PathPrefix("/public").Subrouter()
+	public.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {})
+
+	docs := public.PathPrefix("/docs").Subrouter()
+	docs.HandleFunc("/index", func(w http.ResponseWriter, req *http.Request) {})
+
+	require.NoError(t, base.Apply(router, base.Without("auth").With(orderMiddleware("cache", &order)).For(public)))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/public/status", nil))
+	assert.Equal(t, []string{"audit", "cache"}, order, "Should only run the override chain for the subrouter routes")
+
+	order = nil
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/public/docs/index", nil))
+	assert.Equal(t, []string{"audit", "cache"}, order, "Should use the override chain for the nested subrouters")
+
+	order = nil
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+	assert.Equal(t, []string{"auth", "audit"}, order, "Should use the base chain for the other routes")
+
+	invalid := NewChain(LoggingChainMiddleware).For(public)
+	assert.Error(t, base.Apply(mux.NewRouter(), invalid), "Should validate the override chains")
+}
+
+func TestHandlerConfigPreset(t *testing.T) {
+	chain := (&HandlerConfig{
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+		TracingEnabled:     true,
+		RecoveryEnabled:    true,
+	}).Chain()
+
+	assert.Equal(t, []string{CorrelationMiddlewareName, LoggingMiddlewareName, TracingMiddlewareName, RecoveryMiddlewareName}, chain.Names())
+	assert.NoError(t, chain.Validate(), "Should be a valid chain")
+}
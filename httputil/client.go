@@ -1,44 +1,154 @@
 package httputil
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 
 	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/discovery"
 	"github.com/samkreter/go-core/log"
 
-	"github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
 )
 
 const (
 	defaultHTTPClientTimeout = time.Second * 30
+
+	errorCategoryAttribute = "http.error_category"
 )
 
+// ClientConfig holds configuration for which transports to enable
+type ClientConfig struct {
+	CorrelationEnabled bool
+	LoggingEnabled     bool
+	TracingEnabled     bool
+
+	// ConnectionTimingEnabled records dns, connect, tls handshake, time to first byte
+	// and connection reuse on the outgoing request logs and spans
+	ConnectionTimingEnabled bool
+
+	// CacheStore enables response caching when set
+	CacheStore CacheStore
+
+	// TokenSource enables adding bearer tokens to the requests when set
+	TokenSource TokenSource
+
+	// TLSConfig is used for the TLS connections when set, e.g. the ClientTLSConfig of a TLSReloader
+	TLSConfig *tls.Config
+
+	// TLSReloader is used for the TLS connections instead of the TLSConfig when set,
+	// the new connections use the reloaded client certificate and CA bundle
+	TLSReloader *TLSReloader
+
+	// DestinationGuard restricts the destinations the client can connect to when set
+	DestinationGuard *DestinationGuard
+
+	// Resolver enables resolving logical service names to endpoints when set
+	Resolver        discovery.Resolver
+	BalancingPolicy BalancingPolicy
+}
+
 // NewHTTPClient creates a new http client with tracing and logging enabled
 func NewHTTPClient(correlationEnabled, loggingEnabled, tracingEnabled bool) *http.Client {
+	return NewHTTPClientWithConfig(&ClientConfig{
+		CorrelationEnabled: correlationEnabled,
+		LoggingEnabled:     loggingEnabled,
+		TracingEnabled:     tracingEnabled,
+	})
+}
+
+// NewHTTPClientWithConfig creates a new http client with the transports enabled in the config
+func NewHTTPClientWithConfig(config *ClientConfig) *http.Client {
+	baseTransport := &http.Transport{
+		TLSClientConfig: config.TLSConfig,
+	}
+
+	if config.DestinationGuard != nil {
+		baseTransport.DialContext = config.DestinationGuard.DialContext
+	}
+
+	if config.TLSReloader != nil {
+		baseTransport.DialTLSContext = config.TLSReloader.DialTLSContext(baseTransport.DialContext)
+	} else if config.Resolver != nil {
+		// Verify the load balanced endpoints against the service name instead of their address
+		baseTransport.DialTLSContext = dialTLSContext(func() *tls.Config {
+			if config.TLSConfig == nil {
+				return &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+			return config.TLSConfig.Clone()
+		}, baseTransport.DialContext)
+	}
+
 	var transport http.RoundTripper
-	transport = &http.Transport{}
+	transport = baseTransport
+
+	// Add load balancing transport
+	// Note: this must be wrapped by the caching transport so responses are cached by the service name
+	if config.Resolver != nil {
+		transport = &LoadBalancingTransport{
+			Transport: transport,
+			Resolver:  config.Resolver,
+			Policy:    config.BalancingPolicy,
+		}
+	}
+
+	// Add response caching transport
+	// Note: this must be wrapped by the logging transport so cache hits are logged
+	if config.CacheStore != nil {
+		transport = &CachingTransport{
+			Transport: transport,
+			Store:     config.CacheStore,
+		}
+	}
 
 	// Add outgoing request logging transport
-	if loggingEnabled {
+	if config.LoggingEnabled {
 		transport = &LogTransport{
-			Transport: transport,
+			Transport:               transport,
+			ConnectionTimingEnabled: config.ConnectionTimingEnabled,
+		}
+	}
+
+	// Add bearer token transport
+	// Note: this wraps the logging transport so a retry after a 401 is logged as its own request
+	if config.TokenSource != nil {
+		transport = &AuthTransport{
+			Transport:   transport,
+			TokenSource: config.TokenSource,
 		}
 	}
 
 	// Add correlation propegation transport
-	if correlationEnabled {
+	if config.CorrelationEnabled {
 		transport = &CorrelationTransport{
 			Transport: transport,
 		}
 	}
 
 	// Add tracing transport
-	if tracingEnabled {
-		transport = &ochttp.Transport{
+	if config.TracingEnabled {
+		tracingTransport := &ochttp.Transport{
 			Base: transport,
 		}
+
+		if config.ConnectionTimingEnabled {
+			tracingTransport.NewClientTrace = newSpanTimingClientTrace
+		}
+
+		transport = tracingTransport
+	}
+
+	// Add destination checks
+	// Note: this must be the last transport so every redirect is checked before any other transport runs
+	if config.DestinationGuard != nil {
+		transport = &DestinationGuardTransport{
+			Transport: transport,
+			Guard:     config.DestinationGuard,
+		}
 	}
 
 	return &http.Client{
@@ -67,19 +177,69 @@ func (t *CorrelationTransport) RoundTrip(req *http.Request) (*http.Response, err
 // When set as Transport of http.Client, it executes HTTP requests with logging.
 type LogTransport struct {
 	Transport http.RoundTripper
+
+	// ConnectionTimingEnabled adds the connection level timings to the
+	// "Outgoing Http Request Ended" log
+	ConnectionTimingEnabled bool
 }
 
 // RoundTrip implements http.RoundTripper and adds logging the client requests
 func (t *LogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	endLogging := StartLogOutgoingRequest(req)
+	var timings *connTimings
+	if t.ConnectionTimingEnabled {
+		timings = newConnTimings(nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.clientTrace()))
+	}
+
+	// Allow the wrapped transports to add their own fields to the request log
+	req = req.WithContext(context.WithValue(req.Context(), outgoingLogFieldsKey{}, newLogFields()))
+
+	endLogging := startLogOutgoingRequest(req, timings)
 
 	resp, err := t.transport().RoundTrip(req)
+	if err != nil {
+		// The category is part of the error so it is also in the span status set by the tracing transport
+		err = &OutgoingRequestError{
+			Category: classifyRequestError(req, err),
+			Err:      err,
+		}
+	}
 
 	endLogging(resp, err)
 
 	return resp, err
 }
 
+// classifyRequestError classifies the error using the request context when the transport
+// only reports the request as canceled, e.g. after the http.Client timeout
+func classifyRequestError(req *http.Request, err error) ErrorCategory {
+	category := ClassifyError(err)
+	if category != ErrorCategoryUnknown {
+		return category
+	}
+
+	ctx := req.Context()
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return ErrorCategoryTimeout
+	}
+
+	if ctx.Err() != nil {
+		return ClassifyError(ctx.Err())
+	}
+
+	return category
+}
+
+type outgoingLogFieldsKey struct{}
+
+// AddOutgoingLogField adds a field to the "Outgoing Http Request Ended" log of the request.
+// Only has an effect for transports wrapped by a LogTransport.
+func AddOutgoingLogField(req *http.Request, key string, value interface{}) {
+	if extra, ok := req.Context().Value(outgoingLogFieldsKey{}).(*logFields); ok {
+		extra.set(key, value)
+	}
+}
+
 func (t *LogTransport) transport() http.RoundTripper {
 	if t.Transport != nil {
 		return t.Transport
@@ -91,9 +251,13 @@ func (t *LogTransport) transport() http.RoundTripper {
 // StartLogOutgoingRequest logs the outgoing requests. Returns the end function when
 // the request is finished
 func StartLogOutgoingRequest(req *http.Request) (endReqLog func(resp *http.Response, err error)) {
+	return startLogOutgoingRequest(req, nil)
+}
+
+func startLogOutgoingRequest(req *http.Request, timings *connTimings) (endReqLog func(resp *http.Response, err error)) {
 	ctx := req.Context()
 
-	fields := logrus.Fields{
+	fields := log.Fields{
 		"httpMethod":    req.Method,
 		"targetUri":     req.URL.String(),
 		"hostName":      req.Host,
@@ -111,9 +275,38 @@ func StartLogOutgoingRequest(req *http.Request) (endReqLog func(resp *http.Respo
 	startTime := time.Now()
 
 	return func(resp *http.Response, err error) {
+		fields["durationInMilliseconds"] = time.Now().Sub(startTime)
+
+		if timings != nil {
+			for k, v := range timings.fields() {
+				fields[k] = v
+			}
+		}
+
+		if extra, ok := ctx.Value(outgoingLogFieldsKey{}).(*logFields); ok {
+			extra.addTo(fields)
+		}
+
+		// Transport failures have no response
+		if err != nil {
+			category := ClassifyError(err)
+			fields["errorCategory"] = string(category)
+
+			if span := trace.FromContext(ctx); span != nil {
+				span.AddAttributes(trace.StringAttribute(errorCategoryAttribute, string(category)))
+			}
+
+			log.G(ctx).WithFields(fields).WithError(err).Error("Outgoing Http Request Ended")
+			return
+		}
+
 		fields["contentLength"] = resp.ContentLength
 		fields["httpStatusCode"] = resp.StatusCode
-		fields["durationInMilliseconds"] = time.Now().Sub(startTime)
+
+		if resp.StatusCode >= 500 {
+			log.G(ctx).WithFields(fields).Warn("Outgoing Http Request Ended")
+			return
+		}
 
 		log.G(ctx).WithFields(fields).Debug("Outgoing Http Request Ended")
 	}
@@ -1,9 +1,12 @@
 package httputil
 
 import (
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	logrustest "github.com/sirupsen/logrus/hooks/test"
@@ -119,3 +122,111 @@ func TestClientWithCorrelation(t *testing.T) {
 
 	assert.Equal(t, 200, resp.StatusCode, "Should get OK status code.")
 }
+
+func TestOutgoingRequestConnectionTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`OK`))
+	}))
+
+	defer server.Close()
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := NewHTTPClientWithConfig(&ClientConfig{
+		LoggingEnabled:          true,
+		ConnectionTimingEnabled: true,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(server.URL)
+		require.NoError(t, err, "Should not get error for server response")
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, 4, len(testHook.Entries), "Should have correct number of outgoing logs")
+
+	firstEnd := testHook.Entries[1].Data
+	assert.Equal(t, false, firstEnd["connectionReused"], "First request should use a new connection")
+	assert.Contains(t, firstEnd, "connectDuration", "Should record the connect duration")
+	assert.Contains(t, firstEnd, "timeToFirstByte", "Should record the time to first byte")
+
+	secondEnd := testHook.Entries[3].Data
+	assert.Equal(t, true, secondEnd["connectionReused"], "Second request should reuse the connection")
+	assert.NotContains(t, secondEnd, "connectDuration", "Reused connection should not record a connect duration")
+}
+
+func TestConnTimingsRacingDials(t *testing.T) {
+	timings := newConnTimings(nil)
+	trace := timings.clientTrace()
+
+	trace.ConnectStart("tcp", "[2001:db8::1]:443")
+	trace.ConnectStart("tcp", "192.0.2.1:443")
+	trace.ConnectDone("tcp", "[2001:db8::1]:443", errors.New("unreachable"))
+	trace.ConnectDone("tcp", "192.0.2.1:443", nil)
+
+	connectDone := timings.connectDone
+	require.False(t, connectDone.IsZero(), "Should record the successful dial")
+
+	trace.ConnectDone("tcp", "192.0.2.2:443", nil)
+	assert.Equal(t, connectDone, timings.connectDone, "Should keep the first successful dial")
+}
+
+func TestOutgoingRequestFailureLogging(t *testing.T) {
+	closedServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	closedServer.Close()
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Millisecond * 200)
+	}))
+	defer slowServer.Close()
+
+	tt := []struct {
+		name     string
+		url      string
+		timeout  time.Duration
+		category ErrorCategory
+	}{
+		{"Connection Refused", closedServer.URL, 0, ErrorCategoryConnectionRefused},
+		{"DNS Failure", "http://go-core.invalid", 0, ErrorCategoryDNS},
+		{"Timeout", slowServer.URL, time.Millisecond * 50, ErrorCategoryTimeout},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			logrus.SetLevel(logrus.DebugLevel)
+			testHook := logrustest.NewGlobal()
+
+			c := NewHTTPClient(true, true, true)
+			if tc.timeout != 0 {
+				c.Timeout = tc.timeout
+			}
+
+			_, err := c.Get(tc.url)
+			require.Error(t, err, "Should get a transport error")
+			assert.Equal(t, tc.category, ClassifyError(err), "Should return the classified error")
+
+			entry := testHook.LastEntry()
+			assert.Equal(t, logrus.ErrorLevel, entry.Level, "Should log transport failures as errors")
+			assert.Equal(t, string(tc.category), getValueFromLog(*entry, "errorCategory", t), "Should log the error category")
+			assert.NotNil(t, entry.Data[logrus.ErrorKey], "Should log the error")
+		})
+	}
+}
+
+func TestOutgoingRequestServerErrorLogging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	resp, err := NewHTTPClient(false, true, false).Get(server.URL)
+	require.NoError(t, err, "Should not get error for server response")
+	resp.Body.Close()
+
+	assert.Equal(t, logrus.WarnLevel, testHook.LastEntry().Level, "Should log 5xx responses as warnings")
+}
@@ -0,0 +1,271 @@
+package httputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/samkreter/go-core/correlation"
+	"go.opencensus.io/trace"
+)
+
+const (
+	clientInfoContextKey = contextKey("clientInfo")
+
+	// ClientIPMiddlewareName is the name of the ClientIPResolver middleware in a Chain
+	ClientIPMiddlewareName = "clientip"
+
+	clientIPAttribute = "http.client_ip"
+	schemeAttribute   = "http.scheme"
+)
+
+// Forwarding headers set by the proxies
+var (
+	ForwardedHeader       = "Forwarded"
+	XForwardedForHeader   = "X-Forwarded-For"
+	XForwardedProtoHeader = "X-Forwarded-Proto"
+	XForwardedHostHeader  = "X-Forwarded-Host"
+)
+
+func init() {
+	// Check the debug escalation allowed networks against the client behind the trusted proxies
+	correlation.SetClientIPFunc(ClientIP)
+}
+
+// ClientInfo is the original client of a request forwarded by proxies
+type ClientInfo struct {
+	IP     string
+	Scheme string
+	Host   string
+}
+
+// ClientIPConfig holds the configuration for the ClientIPResolver
+type ClientIPConfig struct {
+	// TrustedProxies are the CIDRs of the proxies allowed to set the forwarding headers.
+	// The forwarding headers are ignored by default.
+	TrustedProxies []string
+}
+
+// ClientIPResolver resolves the original client of the requests from the forwarding headers of the trusted proxies
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// forwardedHop is an element of the forwarding chain, the closest proxy is the last hop
+type forwardedHop struct {
+	ip    string
+	proto string
+	host  string
+}
+
+// NewClientIPResolver creates a new ClientIPResolver from the config
+func NewClientIPResolver(config ClientIPConfig) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{}
+
+	for _, cidr := range config.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, network)
+	}
+
+	return r, nil
+}
+
+// Middleware adds the ClientInfo of the request to the context.
+// It runs before the logging and tracing middlewares so they use the resolved client.
+func (r *ClientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := WithClientInfo(req.Context(), r.Resolve(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// ChainMiddleware returns the middleware of the resolver for a Chain
+func (r *ClientIPResolver) ChainMiddleware() ChainMiddleware {
+	return ChainMiddleware{
+		Name:       ClientIPMiddlewareName,
+		Middleware: r.Middleware,
+	}
+}
+
+// Resolve walks the forwarding chain from the connected peer and stops at the first address
+// that is not a trusted proxy, the hops before it could have been set by the client.
+// The Forwarded header is used when present, otherwise the X-Forwarded-* headers.
+func (r *ClientIPResolver) Resolve(req *http.Request) ClientInfo {
+	info := ClientInfo{
+		IP:     remoteIP(req),
+		Scheme: "http",
+		Host:   req.Host,
+	}
+
+	if req.TLS != nil {
+		info.Scheme = "https"
+	}
+
+	if !r.isTrusted(info.IP) {
+		return info
+	}
+
+	hops := parseForwarded(req.Header[ForwardedHeader])
+	if len(hops) == 0 {
+		hops = parseXForwarded(req)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+
+		// Stop at an obfuscated or invalid address, the trusted proxy that forwarded it is the client
+		if net.ParseIP(hop.ip) == nil {
+			break
+		}
+
+		info.IP = hop.ip
+		if hop.proto != "" {
+			info.Scheme = strings.ToLower(hop.proto)
+		}
+		if hop.host != "" {
+			info.Host = hop.host
+		}
+
+		if !r.isTrusted(hop.ip) {
+			break
+		}
+	}
+
+	return info
+}
+
+func (r *ClientIPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range r.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseForwarded parses the RFC 7239 Forwarded headers
+func parseForwarded(headers []string) []forwardedHop {
+	var hops []forwardedHop
+
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			var hop forwardedHop
+
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+
+				value := strings.Trim(kv[1], `"`)
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					hop.ip = forwardedNodeIP(value)
+				case "proto":
+					hop.proto = value
+				case "host":
+					hop.host = value
+				}
+			}
+
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// parseXForwarded parses the X-Forwarded-For headers. The protocol and host are added to the last hop,
+// the values before the last one were appended by the earlier hops or sent by the client.
+func parseXForwarded(req *http.Request) []forwardedHop {
+	var hops []forwardedHop
+
+	for _, header := range req.Header[XForwardedForHeader] {
+		for _, ip := range strings.Split(header, ",") {
+			hops = append(hops, forwardedHop{ip: forwardedNodeIP(strings.TrimSpace(ip))})
+		}
+	}
+
+	if len(hops) > 0 {
+		last := &hops[len(hops)-1]
+		last.proto = lastHeaderValue(req.Header[XForwardedProtoHeader])
+		last.host = lastHeaderValue(req.Header[XForwardedHostHeader])
+	}
+
+	return hops
+}
+
+// forwardedNodeIP returns the IP of a node, e.g. "192.0.2.1", "192.0.2.1:4711" or "[2001:db8::1]:4711"
+func forwardedNodeIP(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// lastHeaderValue returns the last value of a list header, the one appended by the nearest proxy
+func lastHeaderValue(headers []string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+
+	values := strings.Split(headers[len(headers)-1], ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+// WithClientInfo returns a new context with the client of the request
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoContextKey, info)
+}
+
+// GetClientInfo gets the client of the request from a context
+func GetClientInfo(ctx context.Context) (ClientInfo, bool) {
+	info, ok := ctx.Value(clientInfoContextKey).(ClientInfo)
+	return info, ok
+}
+
+// ClientIP returns the resolved client IP of the request, or the address of the connected client
+func ClientIP(req *http.Request) string {
+	if info, ok := GetClientInfo(req.Context()); ok {
+		return info.IP
+	}
+
+	return remoteIP(req)
+}
+
+// remoteIP returns the address of the connected client
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// addClientSpanAttributes adds the resolved client to the server span
+func addClientSpanAttributes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if info, ok := GetClientInfo(req.Context()); ok {
+			if span := trace.FromContext(req.Context()); span != nil {
+				span.AddAttributes(
+					trace.StringAttribute(clientIPAttribute, info.IP),
+					trace.StringAttribute(schemeAttribute, info.Scheme),
+				)
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,104 @@
+package httputil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver(ClientIPConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8::/32"},
+	})
+	require.NoError(t, err, "Should not get error creating the resolver")
+
+	tt := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		tls        bool
+		expected   ClientInfo
+	}{
+		{"No proxy", "192.0.2.1:1234", nil, false,
+			ClientInfo{IP: "192.0.2.1", Scheme: "http", Host: "example.com"}},
+		{"Untrusted peer", "192.0.2.1:1234", map[string]string{XForwardedForHeader: "198.51.100.1"}, true,
+			ClientInfo{IP: "192.0.2.1", Scheme: "https", Host: "example.com"}},
+		{"X-Forwarded-For", "10.0.0.1:1234", map[string]string{
+			XForwardedForHeader:   "203.0.113.9, 198.51.100.1, 10.0.0.2",
+			XForwardedProtoHeader: "https",
+			XForwardedHostHeader:  "api.example.com",
+		}, false,
+			ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "api.example.com"}},
+		{"X-Forwarded-Proto spoofed", "10.0.0.1:1234", map[string]string{
+			XForwardedForHeader:   "203.0.113.9, 198.51.100.1",
+			XForwardedProtoHeader: "http, https",
+			XForwardedHostHeader:  "evil.example.com, api.example.com",
+		}, false,
+			ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "api.example.com"}},
+		{"X-Forwarded-For single hop", "10.0.0.1:1234", map[string]string{
+			XForwardedForHeader:   "198.51.100.1",
+			XForwardedProtoHeader: "https",
+			XForwardedHostHeader:  "api.example.com",
+		}, false,
+			ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "api.example.com"}},
+		{"Forwarded", "10.0.0.1:1234", map[string]string{
+			ForwardedHeader:     `for="[2001:db8:cafe::17]:4711";proto=https;host=api.example.com, for=198.51.100.1;proto=https, for=10.0.0.2`,
+			XForwardedForHeader: "203.0.113.9",
+		}, false,
+			ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "example.com"}},
+		{"Forwarded trusted chain", "10.0.0.1:1234", map[string]string{
+			ForwardedHeader: `for="[2001:db8:cafe::17]:4711";proto=https;host=api.example.com, for=10.0.0.2`,
+		}, false,
+			ClientInfo{IP: "2001:db8:cafe::17", Scheme: "https", Host: "api.example.com"}},
+		{"Obfuscated", "10.0.0.1:1234", map[string]string{ForwardedHeader: "for=_hidden, for=10.0.0.2"}, false,
+			ClientInfo{IP: "10.0.0.2", Scheme: "http", Host: "example.com"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.TLS = nil
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			assert.Equal(t, tc.expected, resolver.Resolve(req))
+		})
+	}
+}
+
+func TestClientIPResolverMiddleware(t *testing.T) {
+	resolver, err := NewClientIPResolver(ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	require.NoError(t, err, "Should not get error creating the resolver")
+
+	var key string
+	handler := SetUpHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key = ClientIPKey(req)
+	}), &HandlerConfig{
+		ClientIPResolver:   resolver,
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+	})
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	req := newAccessLogTestRequest(t)
+	req.Header.Set(XForwardedForHeader, "198.51.100.1")
+	req.Header.Set(XForwardedProtoHeader, "https")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "198.51.100.1", key, "Should use the resolved client IP as the rate limit key")
+	assert.Equal(t, "198.51.100.1", testHook.LastEntry().Data["clientIP"], "Should log the resolved client IP")
+	assert.Equal(t, "https", testHook.LastEntry().Data["scheme"], "Should log the resolved scheme")
+}
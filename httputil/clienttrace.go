@@ -0,0 +1,172 @@
+package httputil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+
+	"go.opencensus.io/trace"
+)
+
+// connTimings records the connection level timings of a single outgoing request
+type connTimings struct {
+	sync.Mutex
+	span *trace.Span
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	reused       bool
+	gotConn      bool
+}
+
+// newConnTimings creates a new connTimings. If span is not nil each completed
+// phase is also added to the span as an annotation.
+func newConnTimings(span *trace.Span) *connTimings {
+	return &connTimings{
+		span:  span,
+		start: time.Now(),
+	}
+}
+
+// newSpanTimingClientTrace returns a httptrace.ClientTrace which annotates the span
+// with the connection level timings. Used as the NewClientTrace of the tracing transport.
+func newSpanTimingClientTrace(_ *http.Request, span *trace.Span) *httptrace.ClientTrace {
+	return newConnTimings(span).clientTrace()
+}
+
+func (c *connTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             c.onDNSStart,
+		DNSDone:              c.onDNSDone,
+		ConnectStart:         c.onConnectStart,
+		ConnectDone:          c.onConnectDone,
+		TLSHandshakeStart:    c.onTLSHandshakeStart,
+		TLSHandshakeDone:     c.onTLSHandshakeDone,
+		GotConn:              c.onGotConn,
+		GotFirstResponseByte: c.onGotFirstResponseByte,
+	}
+}
+
+func (c *connTimings) onDNSStart(_ httptrace.DNSStartInfo) {
+	c.Lock()
+	defer c.Unlock()
+	c.dnsStart = time.Now()
+}
+
+func (c *connTimings) onDNSDone(_ httptrace.DNSDoneInfo) {
+	c.Lock()
+	defer c.Unlock()
+	c.dnsDone = time.Now()
+	c.annotate("DNS Done", "dnsDuration", c.dnsDone.Sub(c.dnsStart))
+}
+
+// onConnectStart keeps the first dial, multiple dials can be started when the host
+// resolves to more than one address.
+func (c *connTimings) onConnectStart(_, _ string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.connectStart.IsZero() {
+		c.connectStart = time.Now()
+	}
+}
+
+// onConnectDone keeps the first successful dial, the connection of a racing dial is closed
+func (c *connTimings) onConnectDone(_, _ string, err error) {
+	if err != nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if !c.connectDone.IsZero() {
+		return
+	}
+
+	c.connectDone = time.Now()
+	c.annotate("Connect Done", "connectDuration", c.connectDone.Sub(c.connectStart))
+}
+
+func (c *connTimings) onTLSHandshakeStart() {
+	c.Lock()
+	defer c.Unlock()
+	c.tlsStart = time.Now()
+}
+
+func (c *connTimings) onTLSHandshakeDone(_ tls.ConnectionState, _ error) {
+	c.Lock()
+	defer c.Unlock()
+	c.tlsDone = time.Now()
+	c.annotate("TLS Handshake Done", "tlsHandshakeDuration", c.tlsDone.Sub(c.tlsStart))
+}
+
+func (c *connTimings) onGotConn(info httptrace.GotConnInfo) {
+	c.Lock()
+	defer c.Unlock()
+	c.gotConn = true
+	c.reused = info.Reused
+
+	if c.span != nil {
+		c.span.Annotate([]trace.Attribute{
+			trace.BoolAttribute("connectionReused", info.Reused),
+		}, "Got Connection")
+	}
+}
+
+func (c *connTimings) onGotFirstResponseByte() {
+	c.Lock()
+	defer c.Unlock()
+	c.firstByte = time.Now()
+	c.annotate("Got First Response Byte", "timeToFirstByte", c.firstByte.Sub(c.start))
+}
+
+// annotate adds the duration of a completed phase to the span. Must be called with the lock held.
+func (c *connTimings) annotate(message, key string, duration time.Duration) {
+	if c.span == nil {
+		return
+	}
+
+	c.span.Annotate([]trace.Attribute{
+		trace.StringAttribute(key, duration.String()),
+	}, message)
+}
+
+// fields returns the recorded timings as log fields. Phases which did not happen,
+// e.g. dns and connect on a reused connection, are left out.
+func (c *connTimings) fields() log.Fields {
+	c.Lock()
+	defer c.Unlock()
+
+	fields := log.Fields{}
+
+	if c.gotConn {
+		fields["connectionReused"] = c.reused
+	}
+
+	if !c.dnsDone.IsZero() {
+		fields["dnsDuration"] = c.dnsDone.Sub(c.dnsStart)
+	}
+
+	if !c.connectDone.IsZero() {
+		fields["connectDuration"] = c.connectDone.Sub(c.connectStart)
+	}
+
+	if !c.tlsDone.IsZero() {
+		fields["tlsHandshakeDuration"] = c.tlsDone.Sub(c.tlsStart)
+	}
+
+	if !c.firstByte.IsZero() {
+		fields["timeToFirstByte"] = c.firstByte.Sub(c.start)
+	}
+
+	return fields
+}
@@ -0,0 +1,263 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	defaultConcurrencyInitialLimit = 20
+	defaultConcurrencyMinLimit     = 1
+	defaultConcurrencyMaxLimit     = 1000
+	defaultConcurrencyBackoffRatio = 0.9
+	defaultConcurrencyLatency      = time.Second
+
+	defaultRouteClass = "default"
+
+	// gradientSmoothing weights the new limit against the current one
+	gradientSmoothing = 0.2
+
+	// minRTTWindow is how often the minimum latency is reset so it follows changes in the service
+	minRTTWindow = time.Minute
+)
+
+// ConcurrencyAlgorithm adjusts the concurrency limit from the observed requests
+type ConcurrencyAlgorithm string
+
+const (
+	// AIMD increases the limit by one for each full window of successful requests and
+	// multiplies it by the BackoffRatio when a request is slow or overloaded
+	AIMD ConcurrencyAlgorithm = "AIMD"
+
+	// Gradient scales the limit by the ratio of the minimum latency to the current latency
+	Gradient ConcurrencyAlgorithm = "Gradient"
+)
+
+// ConcurrencyLimiterConfig holds the configuration for the ConcurrencyLimiter
+type ConcurrencyLimiterConfig struct {
+	// Algorithm defaults to AIMD
+	Algorithm ConcurrencyAlgorithm
+
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// LatencyThreshold is the latency above which AIMD backs off, defaults to a second
+	LatencyThreshold time.Duration
+
+	// BackoffRatio is the factor AIMD multiplies the limit by when backing off, defaults to 0.9
+	BackoffRatio float64
+
+	// ClassFunc returns the route class of the request, each class has its own limit.
+	// Defaults to a single class for all the requests.
+	ClassFunc KeyExtractor
+}
+
+// ConcurrencyLimiter rejects the requests over an adaptive limit of requests in flight
+type ConcurrencyLimiter struct {
+	config ConcurrencyLimiterConfig
+
+	mu      sync.Mutex
+	classes map[string]*concurrencyLimit
+}
+
+// concurrencyLimit is the state of a route class
+type concurrencyLimit struct {
+	limit    float64
+	inFlight int
+
+	minRTT      time.Duration
+	minRTTReset time.Time
+
+	shedding bool
+	shed     int64
+}
+
+// NewConcurrencyLimiter creates a new ConcurrencyLimiter from the config
+func NewConcurrencyLimiter(config ConcurrencyLimiterConfig) (*ConcurrencyLimiter, error) {
+	if config.Algorithm == "" {
+		config.Algorithm = AIMD
+	}
+
+	if config.Algorithm != AIMD && config.Algorithm != Gradient {
+		return nil, fmt.Errorf("unknown concurrency algorithm %q", config.Algorithm)
+	}
+
+	if config.MinLimit == 0 {
+		config.MinLimit = defaultConcurrencyMinLimit
+	}
+
+	if config.MaxLimit == 0 {
+		config.MaxLimit = defaultConcurrencyMaxLimit
+	}
+
+	if config.InitialLimit == 0 {
+		config.InitialLimit = defaultConcurrencyInitialLimit
+	}
+
+	if config.MinLimit > config.InitialLimit || config.InitialLimit > config.MaxLimit {
+		return nil, fmt.Errorf("configuration must have MinLimit <= InitialLimit <= MaxLimit")
+	}
+
+	if config.LatencyThreshold == 0 {
+		config.LatencyThreshold = defaultConcurrencyLatency
+	}
+
+	if config.BackoffRatio == 0 {
+		config.BackoffRatio = defaultConcurrencyBackoffRatio
+	}
+
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		return nil, fmt.Errorf("configuration must have a BackoffRatio between 0 and 1")
+	}
+
+	if config.ClassFunc == nil {
+		config.ClassFunc = func(*http.Request) string { return defaultRouteClass }
+	}
+
+	return &ConcurrencyLimiter{
+		config:  config,
+		classes: make(map[string]*concurrencyLimit),
+	}, nil
+}
+
+// Middleware responds with 503 Service Unavailable when the route class of the request is at its limit
+func (l *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		class := l.config.ClassFunc(req)
+		ctx, _ := tag.New(req.Context(), tag.Upsert(KeyServerRouteClass, class))
+
+		if !l.acquire(ctx, class) {
+			stats.Record(ctx, ServerShedCount.M(1))
+			AddIncomingLogField(req, "shed", true)
+
+			w.Header().Set("Retry-After", "1")
+			WriteProblem(w, req, NewProblem(http.StatusServiceUnavailable, "the service is overloaded"))
+			return
+		}
+
+		w, rr := newResponseRecorder(w)
+		start := time.Now()
+
+		defer func() {
+			l.release(ctx, class, time.Now().Sub(start), rr.Status())
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Limit returns the current limit of the route class
+func (l *ConcurrencyLimiter) Limit(class string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.class(class).limit)
+}
+
+// class must be called with the lock held
+func (l *ConcurrencyLimiter) class(class string) *concurrencyLimit {
+	state, ok := l.classes[class]
+	if !ok {
+		state = &concurrencyLimit{limit: float64(l.config.InitialLimit)}
+		l.classes[class] = state
+	}
+
+	return state
+}
+
+// acquire reserves a slot for the request, returns false when the class is at its limit
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, class string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.class(class)
+	fields := log.Fields{
+		"routeClass":    class,
+		"limit":         int(state.limit),
+		"inFlight":      state.inFlight,
+		"correlationID": correlation.GetCorrelationID(ctx),
+		"activityID":    correlation.GetActivityID(ctx),
+	}
+
+	if state.inFlight >= int(state.limit) {
+		if !state.shedding {
+			state.shedding = true
+			log.G(ctx).WithFields(fields).Warn("Started shedding load")
+		}
+		state.shed++
+		return false
+	}
+
+	if state.shedding {
+		fields["shedCount"] = state.shed
+		log.G(ctx).WithFields(fields).Info("Stopped shedding load")
+		state.shedding = false
+		state.shed = 0
+	}
+
+	state.inFlight++
+	return true
+}
+
+// release frees the slot of the request and adjusts the limit from its latency and status
+func (l *ConcurrencyLimiter) release(ctx context.Context, class string, rtt time.Duration, status int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.class(class)
+	inFlight := state.inFlight
+	state.inFlight--
+
+	overloaded := status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout ||
+		ctx.Err() == context.DeadlineExceeded
+
+	previous := int(state.limit)
+
+	switch l.config.Algorithm {
+	case Gradient:
+		// Avoid dividing by a zero latency for requests faster than the clock resolution
+		if rtt <= 0 {
+			rtt = time.Nanosecond
+		}
+
+		now := time.Now()
+		if state.minRTT == 0 || rtt < state.minRTT || now.After(state.minRTTReset) {
+			state.minRTT = rtt
+			state.minRTTReset = now.Add(minRTTWindow)
+		}
+
+		gradient := math.Max(0.5, math.Min(1, float64(state.minRTT)/float64(rtt)))
+		if overloaded {
+			gradient = 0.5
+		}
+
+		// Allow a queue of the square root of the limit so the limit can grow
+		newLimit := state.limit*gradient + math.Sqrt(state.limit)
+		state.limit = state.limit*(1-gradientSmoothing) + newLimit*gradientSmoothing
+	default:
+		if overloaded || rtt > l.config.LatencyThreshold {
+			state.limit *= l.config.BackoffRatio
+		} else if float64(inFlight)*2 >= state.limit {
+			// Only grow when the limit is being used
+			state.limit += 1 / state.limit
+		}
+	}
+
+	state.limit = math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), state.limit))
+
+	if int(state.limit) != previous {
+		stats.Record(ctx, ServerConcurrencyLimit.M(int64(state.limit)))
+	}
+}
@@ -0,0 +1,117 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestConcurrencyLimiterSheds(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(ConcurrencyLimiterConfig{
+		InitialLimit: 2,
+		MinLimit:     1,
+		MaxLimit:     2,
+		ClassFunc:    RouteKey,
+	})
+	require.NoError(t, err, "Should not get error creating the limiter")
+
+	require.NoError(t, view.Register(ServerShedCountView))
+	defer view.Unregister(ServerShedCountView)
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	handler := CorrelationMiddleware(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	})))
+
+	send := func(path string) int {
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err, "Should not get error when creating a request")
+		AddStandardRequestHeaders(req)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send("/slow")
+		}()
+		<-started
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, send("/slow"), "Should shed the request over the limit")
+	assert.Equal(t, http.StatusServiceUnavailable, send("/slow"), "Should keep shedding")
+	assert.Equal(t, http.StatusOK, send("/fast"), "Should limit each route class separately")
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, http.StatusOK, send("/slow"), "Should accept the request after the load drops")
+
+	var messages []string
+	for _, entry := range testHook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"Started shedding load", "Stopped shedding load"}, messages, "Should log when shedding starts and stops")
+	assert.Equal(t, int64(2), testHook.LastEntry().Data["shedCount"])
+	assert.Equal(t, testCorrelationID, testHook.Entries[0].Data["correlationID"], "Should log the correlation ID")
+
+	rows, err := view.RetrieveData(ServerShedCountView.Name)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rows), "Should record the shed requests")
+	assert.Equal(t, int64(2), rows[0].Data.(*view.CountData).Value)
+}
+
+func TestConcurrencyLimiterAdjustsLimit(t *testing.T) {
+	tt := []struct {
+		algorithm ConcurrencyAlgorithm
+	}{
+		{AIMD},
+		{Gradient},
+	}
+
+	for _, tc := range tt {
+		t.Run(string(tc.algorithm), func(t *testing.T) {
+			limiter, err := NewConcurrencyLimiter(ConcurrencyLimiterConfig{
+				Algorithm:        tc.algorithm,
+				InitialLimit:     10,
+				LatencyThreshold: time.Millisecond * 50,
+			})
+			require.NoError(t, err, "Should not get error creating the limiter")
+
+			ctx := httptest.NewRequest("GET", "/", nil).Context()
+
+			for i := 0; i < 5; i++ {
+				require.True(t, limiter.acquire(ctx, defaultRouteClass))
+				limiter.release(ctx, defaultRouteClass, time.Millisecond*10, http.StatusOK)
+			}
+			healthy := limiter.Limit(defaultRouteClass)
+
+			for i := 0; i < 5; i++ {
+				require.True(t, limiter.acquire(ctx, defaultRouteClass))
+				limiter.release(ctx, defaultRouteClass, time.Millisecond*100, http.StatusOK)
+			}
+
+			assert.True(t, limiter.Limit(defaultRouteClass) < healthy, "Should lower the limit when the latency increases")
+		})
+	}
+}
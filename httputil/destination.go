@@ -0,0 +1,315 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultDialTimeout   = time.Second * 30
+	defaultDialKeepAlive = time.Second * 30
+)
+
+// DefaultDeniedCIDRs are the loopback, private, link-local (including cloud metadata
+// endpoints), shared, multicast and reserved ranges, and the NAT64 and 6to4 ranges embedding IPv4 addresses
+var DefaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// BlockedDestinationError is returned when the destination of an outgoing request is not allowed
+type BlockedDestinationError struct {
+	Host   string
+	IP     net.IP
+	Reason string
+}
+
+func (e *BlockedDestinationError) Error() string {
+	if e.IP != nil {
+		return fmt.Sprintf("destination %s (%s) blocked: %s", e.Host, e.IP, e.Reason)
+	}
+
+	return fmt.Sprintf("destination %s blocked: %s", e.Host, e.Reason)
+}
+
+// DestinationGuardConfig configuration for the DestinationGuard. Rules are checked in the
+// order: DenyHosts, AllowHosts, AllowCIDRs, DenyCIDRs, DenyByDefault.
+type DestinationGuardConfig struct {
+	// AllowHosts are hostname patterns which are always allowed, e.g. "customers" or "*.example.com"
+	AllowHosts []string
+
+	// DenyHosts are hostname patterns which are always blocked
+	DenyHosts []string
+
+	// AllowCIDRs are address ranges allowed even if they are in the DenyCIDRs
+	AllowCIDRs []string
+
+	// DenyCIDRs are the blocked address ranges, defaults to DefaultDeniedCIDRs
+	DenyCIDRs []string
+
+	// DenyByDefault blocks all destinations not matching AllowHosts or AllowCIDRs
+	DenyByDefault bool
+}
+
+// DestinationGuard checks the destinations of outgoing requests after DNS resolution
+type DestinationGuard struct {
+	allowHosts    []string
+	denyHosts     []string
+	allowCIDRs    []*net.IPNet
+	denyCIDRs     []*net.IPNet
+	denyByDefault bool
+
+	dialer   *net.Dialer
+	resolver *net.Resolver
+}
+
+// NewDestinationGuard creates a new destination guard
+func NewDestinationGuard(config DestinationGuardConfig) (*DestinationGuard, error) {
+	if config.DenyCIDRs == nil {
+		config.DenyCIDRs = DefaultDeniedCIDRs
+	}
+
+	allowCIDRs, err := parseCIDRs(config.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	denyCIDRs, err := parseCIDRs(config.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DestinationGuard{
+		allowHosts:    normalizeAll(config.AllowHosts),
+		denyHosts:     normalizeAll(config.DenyHosts),
+		allowCIDRs:    allowCIDRs,
+		denyCIDRs:     denyCIDRs,
+		denyByDefault: config.DenyByDefault,
+		dialer: &net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: defaultDialKeepAlive,
+		},
+		resolver: net.DefaultResolver,
+	}, nil
+}
+
+// DialContext resolves the address and only connects to the allowed IPs. Use as the
+// DialContext of the http.Transport so the checked IP is the one connected to.
+func (g *DestinationGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if blocked := g.checkHost(host); blocked != nil {
+		return nil, g.logBlocked(ctx, blocked)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := g.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	var blocked *BlockedDestinationError
+	var dialErr error
+	for _, ip := range ips {
+		if blocked = g.checkIP(host, ip); blocked != nil {
+			continue
+		}
+
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = err
+	}
+
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	if blocked == nil {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	return nil, g.logBlocked(ctx, blocked)
+}
+
+// checkHost checks the hostname patterns and literal IPs before resolution
+func (g *DestinationGuard) checkHost(host string) *BlockedDestinationError {
+	host = normalizeHost(host)
+
+	if matchHost(g.denyHosts, host) {
+		return &BlockedDestinationError{Host: host, Reason: "host is denied"}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return g.checkIP(host, ip)
+	}
+
+	return nil
+}
+
+// checkIP checks a resolved IP of the host, the host must have passed checkHost
+func (g *DestinationGuard) checkIP(host string, ip net.IP) *BlockedDestinationError {
+	host = normalizeHost(host)
+
+	if matchHost(g.allowHosts, host) || containsIP(g.allowCIDRs, ip) {
+		return nil
+	}
+
+	if containsIP(g.denyCIDRs, ip) {
+		return &BlockedDestinationError{Host: host, IP: ip, Reason: "address is denied"}
+	}
+
+	if g.denyByDefault {
+		return &BlockedDestinationError{Host: host, IP: ip, Reason: "destination is not allowed"}
+	}
+
+	return nil
+}
+
+func (g *DestinationGuard) logBlocked(ctx context.Context, blocked *BlockedDestinationError) error {
+	fields := log.Fields{
+		"targetHost":    blocked.Host,
+		"reason":        blocked.Reason,
+		"correlationID": correlation.GetCorrelationID(ctx),
+		"activityID":    correlation.GetActivityID(ctx),
+	}
+
+	if blocked.IP != nil {
+		fields["targetIp"] = blocked.IP.String()
+	}
+
+	log.G(ctx).WithFields(fields).Warn("Outgoing request destination blocked")
+
+	return blocked
+}
+
+// DestinationGuardTransport implements http.RoundTripper.
+// When set as Transport of http.Client, it blocks requests, including every redirect,
+// to destinations not allowed by the guard. The wrapped transport must dial with
+// the guard's DialContext to check the resolved addresses.
+type DestinationGuardTransport struct {
+	Transport http.RoundTripper
+	Guard     *DestinationGuard
+}
+
+// NewDestinationGuardTransport creates a guarded transport dialing through the guard
+func NewDestinationGuardTransport(guard *DestinationGuard) *DestinationGuardTransport {
+	return &DestinationGuardTransport{
+		Transport: &http.Transport{
+			DialContext: guard.DialContext,
+		},
+		Guard: guard,
+	}
+}
+
+// RoundTrip implements http.RoundTripper and checks the destination of the client requests
+func (t *DestinationGuardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, t.Guard.logBlocked(req.Context(), &BlockedDestinationError{
+			Host:   req.URL.Hostname(),
+			Reason: fmt.Sprintf("scheme %q is not allowed", req.URL.Scheme),
+		})
+	}
+
+	if blocked := t.Guard.checkHost(req.URL.Hostname()); blocked != nil {
+		return nil, t.Guard.logBlocked(req.Context(), blocked)
+	}
+
+	return t.transport().RoundTrip(req)
+}
+
+func (t *DestinationGuardTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+
+	return http.DefaultTransport
+}
+
+// matchHost matches the host against exact or "*." wildcard patterns
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsIP(cidrs []*net.IPNet, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		_, cidr, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", value, err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+
+	return cidrs, nil
+}
+
+// normalizeAll normalizes the host patterns, see normalizeHost
+func normalizeAll(values []string) []string {
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		normalized = append(normalized, normalizeHost(value))
+	}
+
+	return normalized
+}
+
+// normalizeHost lowercases the host and removes the trailing dot of a fully qualified name
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,117 @@
+package httputil
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/go-core/correlation"
+)
+
+func TestDestinationGuardRules(t *testing.T) {
+	guard, err := NewDestinationGuard(DestinationGuardConfig{
+		AllowHosts: []string{"customers", "*.internal.example.com"},
+		DenyHosts:  []string{"metadata.google.internal"},
+		AllowCIDRs: []string{"10.1.0.0/16"},
+	})
+	require.NoError(t, err, "Should not get error creating the guard")
+
+	tt := []struct {
+		name    string
+		host    string
+		ip      string
+		blocked bool
+	}{
+		{"Public address", "example.com", "93.184.216.34", false},
+		{"Metadata address", "example.com", "169.254.169.254", true},
+		{"Loopback address", "localhost", "127.0.0.1", true},
+		{"Private IPv6 address", "example.com", "fd00::1", true},
+		{"Allowed host", "customers", "10.0.0.5", false},
+		{"Allowed wildcard host", "orders.internal.example.com", "10.0.0.6", false},
+		{"Allowed CIDR", "example.com", "10.1.2.3", false},
+		{"Denied host", "metadata.google.internal", "93.184.216.34", true},
+		{"Denied fully qualified host", "metadata.google.internal.", "93.184.216.34", true},
+		{"Denied mixed case host", "Metadata.Google.Internal", "93.184.216.34", true},
+		{"Allowed fully qualified host", "customers.", "10.0.0.5", false},
+		{"NAT64 address", "example.com", "64:ff9b::a9fe:a9fe", true},
+		{"6to4 address", "example.com", "2002:a9fe:a9fe::1", true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			blocked := guard.checkHost(tc.host)
+			if blocked == nil {
+				blocked = guard.checkIP(tc.host, net.ParseIP(tc.ip))
+			}
+
+			assert.Equal(t, tc.blocked, blocked != nil, "Should get the correct result")
+		})
+	}
+}
+
+func TestDestinationGuardBlocksRedirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	guard, err := NewDestinationGuard(DestinationGuardConfig{
+		AllowCIDRs: []string{"127.0.0.0/8"},
+	})
+	require.NoError(t, err, "Should not get error creating the guard")
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := NewHTTPClientWithConfig(&ClientConfig{
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+		DestinationGuard:   guard,
+	})
+
+	ctx := correlation.CreateCtxFromRequest(&http.Request{})
+	req, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err, "Should not get error while creating reqeust")
+
+	_, err = c.Do(req.WithContext(ctx))
+	require.Error(t, err, "Should block the redirect")
+
+	var blocked *BlockedDestinationError
+	require.True(t, errors.As(err, &blocked), "Should return a BlockedDestinationError")
+	assert.Equal(t, "169.254.169.254", blocked.Host)
+
+	entry := testHook.LastEntry()
+	assert.Equal(t, "Outgoing request destination blocked", entry.Message)
+	assert.Equal(t, correlation.GetCorrelationID(ctx), getValueFromLog(*entry, "correlationID", t), "Should log the correlation ID")
+}
+
+func TestDestinationGuardDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+
+	guard, err := NewDestinationGuard(DestinationGuardConfig{})
+	require.NoError(t, err, "Should not get error creating the guard")
+
+	// Only the dialer is guarded so the check happens after resolution
+	c := &http.Client{
+		Transport: &http.Transport{
+			DialContext: guard.DialContext,
+		},
+	}
+
+	_, err = c.Get(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+	require.Error(t, err, "Should block the resolved loopback address")
+
+	var blocked *BlockedDestinationError
+	assert.True(t, errors.As(err, &blocked), "Should return a BlockedDestinationError")
+}
@@ -0,0 +1,137 @@
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ErrorCategory classifies the cause of a failed outgoing request
+type ErrorCategory string
+
+const (
+	// ErrorCategoryDNS the host could not be resolved
+	ErrorCategoryDNS ErrorCategory = "dns"
+
+	// ErrorCategoryConnectionRefused the server refused the connection
+	ErrorCategoryConnectionRefused ErrorCategory = "connection_refused"
+
+	// ErrorCategoryTLS the tls handshake or certificate verification failed
+	ErrorCategoryTLS ErrorCategory = "tls"
+
+	// ErrorCategoryTimeout the request or one of its phases timed out
+	ErrorCategoryTimeout ErrorCategory = "timeout"
+
+	// ErrorCategoryContextCanceled the request context was canceled
+	ErrorCategoryContextCanceled ErrorCategory = "context_canceled"
+
+	// ErrorCategoryConnectionReset the connection was reset or closed by the server
+	ErrorCategoryConnectionReset ErrorCategory = "connection_reset"
+
+	// ErrorCategoryBlocked the destination was blocked by the DestinationGuard
+	ErrorCategoryBlocked ErrorCategory = "blocked"
+
+	// ErrorCategoryUnknown the error could not be classified
+	ErrorCategoryUnknown ErrorCategory = "unknown"
+)
+
+// OutgoingRequestError is returned by the LogTransport when the request failed in the transport
+type OutgoingRequestError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *OutgoingRequestError) Error() string {
+	return string(e.Category) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying transport error
+func (e *OutgoingRequestError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the error was a timeout, keeping the net.Error behaviour of the transport error
+func (e *OutgoingRequestError) Timeout() bool {
+	return e.Category == ErrorCategoryTimeout
+}
+
+// Temporary reports whether the error is temporary, keeping the net.Error behaviour of the transport error
+func (e *OutgoingRequestError) Temporary() bool {
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		return netErr.Temporary()
+	}
+
+	return false
+}
+
+// ClassifyError returns the category of a transport error
+func ClassifyError(err error) ErrorCategory {
+	var requestErr *OutgoingRequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.Category
+	}
+
+	var blockedErr *BlockedDestinationError
+	if errors.As(err, &blockedErr) {
+		return ErrorCategoryBlocked
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ErrorCategoryContextCanceled
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ErrorCategoryTimeout
+		}
+		return ErrorCategoryDNS
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorCategoryTimeout
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorCategoryConnectionRefused
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorCategoryConnectionReset
+	}
+
+	if isTLSError(err) {
+		return ErrorCategoryTLS
+	}
+
+	return ErrorCategoryUnknown
+}
+
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+
+	if errors.As(err, &recordErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &certInvalidErr) {
+		return true
+	}
+
+	// Handshake alerts are not exported as types
+	return strings.Contains(err.Error(), "tls: ")
+}
@@ -0,0 +1,360 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultIdempotencyTTL          = time.Hour * 24
+	defaultIdempotencyMaxBodyBytes = 1 << 20
+)
+
+var (
+	// IdempotencyKeyHeader the header holding the client generated key of the request
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on the responses replayed from the store
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// idempotencyExcludedHeaders are the canonical names of the headers not stored for replay,
+	// the RateLimit headers are excluded by prefix
+	idempotencyExcludedHeaders = []string{
+		"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Connection", "Te", "Trailer",
+		"Transfer-Encoding", "Upgrade", "Date", "Retry-After",
+	}
+)
+
+// StoredResponse is a response stored for replay
+type StoredResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// IdempotencyRecord is the state of an idempotency key
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string
+
+	// Response is nil while the first request is in flight
+	Response *StoredResponse
+}
+
+// IdempotencyStore holds the idempotency keys and their responses
+type IdempotencyStore interface {
+	// Reserve marks the key as in flight. It returns the existing record when the key was already used.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+
+	// Complete stores the response of the reserved key
+	Complete(ctx context.Context, key string, response *StoredResponse, ttl time.Duration) error
+
+	// Release removes the reserved key so the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore holding the keys in memory until they expire
+type MemoryIdempotencyStore struct {
+	sync.Mutex
+	records   map[string]*memoryIdempotencyRecord
+	lastSweep time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records:   make(map[string]*memoryIdempotencyRecord),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve marks the key as in flight
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if record, ok := s.records[key]; ok && now.Before(record.expires) {
+		existing := record.IdempotencyRecord
+		return &existing, nil
+	}
+
+	s.records[key] = &memoryIdempotencyRecord{
+		IdempotencyRecord: IdempotencyRecord{Fingerprint: fingerprint},
+		expires:           now.Add(ttl),
+	}
+
+	return nil, nil
+}
+
+// Complete stores the response of the reserved key
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, response *StoredResponse, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if record, ok := s.records[key]; ok {
+		record.Response = response
+		record.expires = time.Now().Add(ttl)
+	}
+
+	return nil
+}
+
+// Release removes the reserved key
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// sweep removes the expired keys at most once a minute, must be called with the lock held
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+
+	for key, record := range s.records {
+		if !now.Before(record.expires) {
+			delete(s.records, key)
+		}
+	}
+
+	s.lastSweep = now
+}
+
+// IdempotencyConfig holds the configuration for the Idempotency middleware
+type IdempotencyConfig struct {
+	// Store holds the keys, defaults to a MemoryIdempotencyStore
+	Store IdempotencyStore
+
+	// TTL is how long the responses are replayed, defaults to 24 hours
+	TTL time.Duration
+
+	// PrincipalFunc returns the caller the keys are scoped to.
+	// Defaults to the token subject, then the request signer, then the client IP.
+	PrincipalFunc KeyExtractor
+
+	// Methods are the request methods handled, defaults to POST and PATCH
+	Methods []string
+
+	// MaxBodyBytes limits the request and response bodies, defaults to 1MB.
+	// Larger responses are not stored.
+	MaxBodyBytes int64
+}
+
+// Idempotency replays the stored response for requests retried with the same Idempotency-Key
+type Idempotency struct {
+	config IdempotencyConfig
+}
+
+// NewIdempotency creates a new Idempotency middleware from the config
+func NewIdempotency(config IdempotencyConfig) (*Idempotency, error) {
+	if config.Store == nil {
+		config.Store = NewMemoryIdempotencyStore()
+	}
+
+	if config.TTL == 0 {
+		config.TTL = defaultIdempotencyTTL
+	}
+
+	if config.PrincipalFunc == nil {
+		config.PrincipalFunc = defaultPrincipal
+	}
+
+	if len(config.Methods) == 0 {
+		config.Methods = []string{"POST", "PATCH"}
+	}
+
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultIdempotencyMaxBodyBytes
+	}
+
+	return &Idempotency{
+		config: config,
+	}, nil
+}
+
+// defaultPrincipal returns the authenticated caller of the request
+func defaultPrincipal(req *http.Request) string {
+	ctx := req.Context()
+
+	if subject := GetSubject(ctx); subject != "" {
+		return subject
+	}
+
+	if signer := GetSigner(ctx); signer != "" {
+		return signer
+	}
+
+	if identity, ok := GetPeerIdentity(ctx); ok {
+		return identity.Subject
+	}
+
+	return ClientIP(req)
+}
+
+// Middleware handles the requests with an Idempotency-Key header
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		idempotencyKey := req.Header.Get(IdempotencyKeyHeader)
+		if idempotencyKey == "" || !containsString(i.config.Methods, req.Method) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, i.config.MaxBodyBytes+1))
+		if err != nil {
+			WriteProblem(w, req, NewProblem(http.StatusBadRequest, "failed to read the request body"))
+			return
+		}
+
+		if int64(len(body)) > i.config.MaxBodyBytes {
+			WriteProblem(w, req, NewProblem(http.StatusRequestEntityTooLarge, "request body is too large for an idempotent request"))
+			return
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		key := i.config.PrincipalFunc(req) + ":" + idempotencyKey
+		fingerprint := requestFingerprint(req, body)
+		AddIncomingLogField(req, "idempotencyKey", idempotencyKey)
+
+		record, err := i.config.Store.Reserve(ctx, key, fingerprint, i.config.TTL)
+		if err != nil {
+			// Fail open so an unavailable store does not take down the service
+			log.G(ctx).WithError(err).Error("Failed to reserve the idempotency key")
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if record != nil {
+			i.handleExisting(w, req, idempotencyKey, fingerprint, record)
+			return
+		}
+
+		cw, rr := newResponseRecorder(w)
+		rr.captureBody(i.config.MaxBodyBytes)
+
+		completed := false
+		defer func() {
+			// Allow a retry when the handler panicked or failed
+			if !completed {
+				if err := i.config.Store.Release(ctx, key); err != nil {
+					log.G(ctx).WithError(err).Error("Failed to release the idempotency key")
+				}
+			}
+		}()
+
+		next.ServeHTTP(cw, req)
+
+		status := rr.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		body, ok := rr.Body()
+		if status >= http.StatusInternalServerError || !ok || rr.Hijacked() {
+			return
+		}
+
+		response := &StoredResponse{
+			StatusCode: status,
+			Header:     replayableHeader(w.Header()),
+			Body:       body,
+		}
+
+		if err := i.config.Store.Complete(ctx, key, response, i.config.TTL); err != nil {
+			log.G(ctx).WithError(err).Error("Failed to store the idempotent response")
+			return
+		}
+
+		completed = true
+	})
+}
+
+// handleExisting replays the stored response or rejects the duplicate request
+func (i *Idempotency) handleExisting(w http.ResponseWriter, req *http.Request, idempotencyKey, fingerprint string, record *IdempotencyRecord) {
+	ctx := req.Context()
+
+	fields := log.Fields{
+		"idempotencyKey": idempotencyKey,
+		"httpMethod":     req.Method,
+		"targetUri":      req.URL.String(),
+		"correlationID":  correlation.GetCorrelationID(ctx),
+		"activityID":     correlation.GetActivityID(ctx),
+	}
+
+	if record.Fingerprint != fingerprint {
+		log.G(ctx).WithFields(fields).Warn("Rejected idempotency key reused with a different request")
+		WriteProblem(w, req, NewProblem(http.StatusUnprocessableEntity, "the idempotency key was used with a different request"))
+		return
+	}
+
+	if record.Response == nil {
+		log.G(ctx).WithFields(fields).Warn("Rejected concurrent request with the same idempotency key")
+		w.Header().Set("Retry-After", "1")
+		WriteProblem(w, req, NewProblem(http.StatusConflict, "a request with the idempotency key is in progress"))
+		return
+	}
+
+	log.G(ctx).WithFields(fields).Debug("Replaying idempotent response")
+	AddIncomingLogField(req, "idempotentReplay", true)
+
+	for k, v := range record.Response.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.Response.StatusCode)
+	w.Write(record.Response.Body)
+}
+
+// requestFingerprint identifies the request a key is used with
+func requestFingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, req.Method+"\n"+req.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayableHeader returns the response headers to store, without the hop-by-hop
+// headers and the headers only valid for the original response
+func replayableHeader(header http.Header) http.Header {
+	replayable := header.Clone()
+
+	for _, k := range header.Values("Connection") {
+		for _, name := range strings.Split(k, ",") {
+			replayable.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for k := range replayable {
+		if containsString(idempotencyExcludedHeaders, k) || strings.HasPrefix(k, "Ratelimit-") {
+			delete(replayable, k)
+		}
+	}
+
+	return replayable
+}
@@ -0,0 +1,154 @@
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newIdempotentRequest(t *testing.T, key, body string) *http.Request {
+	req, err := http.NewRequest("POST", "/orders", strings.NewReader(body))
+	require.NoError(t, err, "Should not get error when creating a request")
+	AddStandardRequestHeaders(req)
+	req.RemoteAddr = "10.0.0.1:54321"
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	return req
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	idempotency, err := NewIdempotency(IdempotencyConfig{})
+	require.NoError(t, err, "Should not get error creating the middleware")
+
+	var calls int32
+	handler := SetUpHandler(idempotency.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"order":%d}`, n)
+	})), &HandlerConfig{
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+	})
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, newIdempotentRequest(t, "key-1", `{"item":"a"}`))
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	retry := httptest.NewRecorder()
+	handler.ServeHTTP(retry, newIdempotentRequest(t, "key-1", `{"item":"a"}`))
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Should not call the handler for the retry")
+	assert.Equal(t, http.StatusCreated, retry.Code, "Should replay the status")
+	assert.Equal(t, first.Body.String(), retry.Body.String(), "Should replay the body")
+	assert.Equal(t, "/orders/1", retry.Header().Get("Location"), "Should replay the headers")
+	assert.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, true, testHook.LastEntry().Data["idempotentReplay"], "Should log the replay in the access log")
+
+	reused := httptest.NewRecorder()
+	handler.ServeHTTP(reused, newIdempotentRequest(t, "key-1", `{"item":"b"}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code, "Should reject a key reused with a different body")
+
+	other := httptest.NewRecorder()
+	handler.ServeHTTP(other, newIdempotentRequest(t, "key-2", `{"item":"a"}`))
+	assert.Equal(t, http.StatusCreated, other.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "Should call the handler for a new key")
+
+	unkeyed := httptest.NewRecorder()
+	handler.ServeHTTP(unkeyed, newIdempotentRequest(t, "", `{"item":"a"}`))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Should call the handler without a key")
+}
+
+func TestIdempotencyConcurrentDuplicate(t *testing.T) {
+	idempotency, err := NewIdempotency(IdempotencyConfig{})
+	require.NoError(t, err, "Should not get error creating the middleware")
+
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	handler := idempotency.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(inFlight)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), newIdempotentRequest(t, "key-1", `{}`))
+		close(done)
+	}()
+
+	<-inFlight
+	duplicate := httptest.NewRecorder()
+	handler.ServeHTTP(duplicate, newIdempotentRequest(t, "key-1", `{}`))
+	assert.Equal(t, http.StatusConflict, duplicate.Code, "Should reject the duplicate while the first is in flight")
+
+	close(release)
+	<-done
+}
+
+func TestIdempotencyReleasesFailures(t *testing.T) {
+	idempotency, err := NewIdempotency(IdempotencyConfig{})
+	require.NoError(t, err, "Should not get error creating the middleware")
+
+	var calls int32
+	handler := idempotency.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	failed := httptest.NewRecorder()
+	handler.ServeHTTP(failed, newIdempotentRequest(t, "key-1", `{}`))
+	assert.Equal(t, http.StatusServiceUnavailable, failed.Code)
+
+	retry := httptest.NewRecorder()
+	handler.ServeHTTP(retry, newIdempotentRequest(t, "key-1", `{}`))
+	assert.Equal(t, http.StatusCreated, retry.Code, "Should retry the request after a server error")
+}
+
+func TestIdempotencyStoredResponse(t *testing.T) {
+	idempotency, err := NewIdempotency(IdempotencyConfig{})
+	require.NoError(t, err, "Should not get error creating the middleware")
+
+	handler := idempotency.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "Should keep the optional interfaces of the writer")
+
+		w.Header().Set("Location", "/orders/1")
+		w.Header().Set("Date", "Mon, 19 Oct 2026 10:00:00 GMT")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "value")
+		w.Header().Set(RateLimitRemainingHeader, "9")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+		w.(http.Flusher).Flush()
+	}))
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, newIdempotentRequest(t, "key-1", `{"item":"a"}`))
+	assert.True(t, first.Flushed, "Should flush the response")
+
+	retry := httptest.NewRecorder()
+	handler.ServeHTTP(retry, newIdempotentRequest(t, "key-1", `{"item":"a"}`))
+	assert.Equal(t, "created", retry.Body.String())
+	assert.Equal(t, "/orders/1", retry.Header().Get("Location"))
+
+	for _, header := range []string{"Date", "Connection", "X-Hop", RateLimitRemainingHeader} {
+		assert.Empty(t, retry.Header().Get(header), "Should not replay the %s header", header)
+	}
+}
@@ -0,0 +1,606 @@
+package httputil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	claimsContextKey = contextKey("claims")
+
+	defaultJWKSRefreshInterval = time.Hour
+	defaultJWTClockSkew        = time.Minute
+	defaultJWTTenantClaim      = "tid"
+
+	// minJWKSRefreshInterval limits the refreshes for tokens signed with an unknown key
+	minJWKSRefreshInterval = time.Second * 10
+
+	// jwksFetchTimeout limits a refresh of the key set, it is not bound to the requests waiting for it
+	jwksFetchTimeout = time.Second * 30
+)
+
+var (
+	errMissingToken      = errors.New("missing bearer token")
+	errMalformedToken    = errors.New("malformed token")
+	errUnknownSigningKey = errors.New("unknown signing key")
+	errInvalidSignature  = errors.New("invalid token signature")
+)
+
+// JWTConfig holds the configuration for the JWTAuthenticator
+type JWTConfig struct {
+	// JWKSURL is the URL of the JSON Web Key Set used to verify the tokens
+	JWKSURL string
+
+	// JWKSFile is the path of the JSON Web Key Set used to verify the tokens, used when JWKSURL is not set
+	JWKSFile string
+
+	// HTTPClient is used to fetch the JWKSURL
+	HTTPClient *http.Client
+
+	// RefreshInterval is how long the keys are cached, defaults to an hour.
+	// The keys are also refreshed when a token is signed with an unknown key.
+	RefreshInterval time.Duration
+
+	// Issuer and Audience are checked when set
+	Issuer   string
+	Audience string
+
+	// ClockSkew is the leeway for the expiry and not before checks, defaults to a minute
+	ClockSkew time.Duration
+
+	// TenantClaim is the claim holding the tenant of the caller, defaults to "tid"
+	TenantClaim string
+
+	// Realm is returned in the WWW-Authenticate header
+	Realm string
+}
+
+// Claims holds the verified claims of a JWT
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Scopes    []string
+	Tenant    string
+
+	// Raw holds all the claims of the token
+	Raw map[string]interface{}
+}
+
+// HasScope returns true when the token was granted the scope
+func (c *Claims) HasScope(scope string) bool {
+	return containsString(c.Scopes, scope)
+}
+
+// String returns the value of a string claim
+func (c *Claims) String(name string) string {
+	value, _ := c.Raw[name].(string)
+	return value
+}
+
+// WithClaims returns a new context with the verified claims
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// GetClaims gets the verified claims from a context
+func GetClaims(ctx context.Context) *Claims {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	if !ok {
+		return nil
+	}
+	return claims
+}
+
+// GetSubject gets the subject of the verified token from a context
+func GetSubject(ctx context.Context) string {
+	if claims := GetClaims(ctx); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+// JWTAuthenticator verifies the bearer tokens of the incoming requests
+type JWTAuthenticator struct {
+	config JWTConfig
+
+	sync.Mutex
+	keys        map[string]jwtKey
+	refreshedAt time.Time
+	attemptedAt time.Time
+
+	// refreshing is closed when the running refresh of the key set completes, nil when no refresh is running
+	refreshing chan struct{}
+}
+
+// NewJWTAuthenticator creates a new JWTAuthenticator from the config
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	if config.JWKSURL == "" && config.JWKSFile == "" {
+		return nil, fmt.Errorf("configuration must have a JWKSURL or JWKSFile")
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultHTTPClientTimeout}
+	}
+
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = defaultJWKSRefreshInterval
+	}
+
+	if config.ClockSkew == 0 {
+		config.ClockSkew = defaultJWTClockSkew
+	}
+
+	if config.TenantClaim == "" {
+		config.TenantClaim = defaultJWTTenantClaim
+	}
+
+	return &JWTAuthenticator{
+		config: config,
+	}, nil
+}
+
+// Middleware rejects the requests without a valid bearer token and adds the claims to the context
+func (a *JWTAuthenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		claims, err := a.authenticate(req)
+		if err != nil {
+			log.G(ctx).WithFields(log.Fields{
+				"httpMethod":    req.Method,
+				"targetUri":     req.URL.String(),
+				"correlationID": correlation.GetCorrelationID(ctx),
+				"activityID":    correlation.GetActivityID(ctx),
+			}).WithError(err).Warn("Rejected request with invalid bearer token")
+
+			challenge := fmt.Sprintf(`Bearer realm=%q`, a.config.Realm)
+			if err != errMissingToken {
+				challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, err.Error())
+			}
+
+			w.Header().Set("WWW-Authenticate", challenge)
+			WriteProblem(w, req, NewProblem(http.StatusUnauthorized, err.Error()))
+			return
+		}
+
+		ctx = WithClaims(ctx, claims)
+		logger := log.G(ctx).WithField("subject", claims.Subject)
+		AddIncomingLogField(req, "subject", claims.Subject)
+
+		if claims.Tenant != "" {
+			ctx = WithTenant(ctx, claims.Tenant)
+			logger = logger.WithField("tenant", claims.Tenant)
+			AddIncomingLogField(req, "tenant", claims.Tenant)
+		}
+
+		next.ServeHTTP(w, req.WithContext(log.WithLogger(ctx, logger)))
+	})
+}
+
+// RequireScopes returns a middleware rejecting the requests whose token was not granted all the scopes.
+// It must run after the JWTAuthenticator middleware, e.g. on the route handler.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			claims := GetClaims(req.Context())
+
+			for _, scope := range scopes {
+				if claims == nil || !claims.HasScope(scope) {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(scopes, " ")))
+					WriteProblem(w, req, NewProblem(http.StatusForbidden, fmt.Sprintf("missing scope %q", scope)))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// authenticate verifies the bearer token of the request and returns its claims
+func (a *JWTAuthenticator) authenticate(req *http.Request) (*Claims, error) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, errMissingToken
+	}
+
+	return a.verify(req.Context(), strings.TrimSpace(authorization[7:]))
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and the claims of the token
+func (a *JWTAuthenticator) verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errMalformedToken
+	}
+
+	key, err := a.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, errInvalidSignature
+	}
+
+	if err := verifyJWTSignature(header.Alg, key.key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	raw := map[string]interface{}{}
+	if err := decodeJWTPart(parts[1], &raw); err != nil {
+		return nil, errMalformedToken
+	}
+
+	claims := a.parseClaims(raw)
+
+	now := time.Now()
+	if claims.ExpiresAt.IsZero() {
+		return nil, errors.New("token has no expiry")
+	}
+
+	if now.After(claims.ExpiresAt.Add(a.config.ClockSkew)) {
+		return nil, errors.New("token is expired")
+	}
+
+	if !claims.NotBefore.IsZero() && now.Add(a.config.ClockSkew).Before(claims.NotBefore) {
+		return nil, errors.New("token is not valid yet")
+	}
+
+	if a.config.Issuer != "" && claims.Issuer != a.config.Issuer {
+		return nil, errors.New("token has an invalid issuer")
+	}
+
+	if a.config.Audience != "" && !containsString(claims.Audience, a.config.Audience) {
+		return nil, errors.New("token has an invalid audience")
+	}
+
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) parseClaims(raw map[string]interface{}) *Claims {
+	claims := &Claims{
+		Raw: raw,
+	}
+
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Tenant, _ = raw[a.config.TenantClaim].(string)
+	claims.ExpiresAt = numericDate(raw["exp"])
+	claims.NotBefore = numericDate(raw["nbf"])
+	claims.IssuedAt = numericDate(raw["iat"])
+	claims.Audience = stringOrList(raw["aud"])
+
+	// Scopes are either space delimited in scope or a list in scp
+	if scope, ok := raw["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	} else {
+		claims.Scopes = stringOrList(raw["scp"])
+	}
+
+	return claims
+}
+
+// key returns the verification key with the ID, refreshing the keys when they expired or the ID is unknown.
+// The cached key is returned while the keys are refreshed, the requests with an unknown key wait for the refresh.
+func (a *JWTAuthenticator) key(ctx context.Context, kid string) (jwtKey, error) {
+	a.Lock()
+	now := time.Now()
+
+	key, ok := a.keys[kid]
+	if ok && now.Sub(a.refreshedAt) < a.config.RefreshInterval {
+		a.Unlock()
+		return key, nil
+	}
+
+	refreshing := a.refresh(now)
+	a.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if refreshing == nil {
+		return jwtKey{}, errUnknownSigningKey
+	}
+
+	// Only stop waiting when the request is cancelled, the refresh continues for the other requests
+	select {
+	case <-refreshing:
+	case <-ctx.Done():
+		return jwtKey{}, ctx.Err()
+	}
+
+	a.Lock()
+	key, ok = a.keys[kid]
+	a.Unlock()
+
+	if !ok {
+		return jwtKey{}, errUnknownSigningKey
+	}
+
+	return key, nil
+}
+
+// refresh starts a refresh of the keys and returns the channel closed once it completes, or returns the running one.
+// It returns nil when the keys were refreshed too recently. The caller must hold the lock.
+func (a *JWTAuthenticator) refresh(now time.Time) chan struct{} {
+	if a.refreshing != nil {
+		return a.refreshing
+	}
+
+	// Allow the keys to be rotated without waiting for the refresh interval,
+	// but do not fetch the key set for every token with an unknown key
+	if now.Sub(a.attemptedAt) < minJWKSRefreshInterval {
+		return nil
+	}
+
+	a.attemptedAt = now
+	refreshing := make(chan struct{})
+	a.refreshing = refreshing
+
+	go func() {
+		defer close(refreshing)
+
+		ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+		defer cancel()
+
+		keys, err := a.loadKeys(ctx)
+
+		a.Lock()
+		defer a.Unlock()
+
+		a.refreshing = nil
+		if err != nil {
+			// Keep using the cached keys when the key set is unavailable
+			log.G(ctx).WithError(err).Error("Failed to refresh the JSON web keys")
+			return
+		}
+
+		a.keys = keys
+		a.refreshedAt = now
+	}()
+
+	return refreshing
+}
+
+// jwtKey is a verification key and the algorithm it is restricted to, if any
+type jwtKey struct {
+	alg string
+	key interface{}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// loadKeys reads the key set and returns the signing keys by ID
+func (a *JWTAuthenticator) loadKeys(ctx context.Context) (map[string]jwtKey, error) {
+	data, err := a.readKeySet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keySet jsonWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, fmt.Errorf("failed to parse the key set: %v", err)
+	}
+
+	keys := make(map[string]jwtKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("kid", jwk.Kid).Warn("Skipping invalid JSON web key")
+			continue
+		}
+
+		keys[jwk.Kid] = jwtKey{alg: jwk.Alg, key: key}
+	}
+
+	return keys, nil
+}
+
+func (a *JWTAuthenticator) readKeySet(ctx context.Context) ([]byte, error) {
+	if a.config.JWKSURL == "" {
+		return ioutil.ReadFile(a.config.JWKSFile)
+	}
+
+	req, err := http.NewRequest("GET", a.config.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.config.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the key set, status code %d", resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// publicKey returns the *rsa.PublicKey, *ecdsa.PublicKey or []byte secret of the key
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(k.K, "="))
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// verifyJWTSignature checks the signature. The algorithm must match the type of the key
+// so a public key cannot be used as an HMAC secret.
+func verifyJWTSignature(alg string, key interface{}, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return errInvalidSignature
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errInvalidSignature
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return errInvalidSignature
+		}
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errInvalidSignature
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// numericDate converts a JSON number of seconds since the epoch
+func numericDate(value interface{}) time.Time {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(seconds), 0)
+}
+
+// stringOrList converts a claim that is either a string or a list of strings
+func stringOrList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package httputil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testJWKS struct {
+	sync.Mutex
+	keys     []map[string]string
+	requests int
+}
+
+func (j *testJWKS) set(keys ...map[string]string) {
+	j.Lock()
+	defer j.Unlock()
+	j.keys = keys
+}
+
+func (j *testJWKS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	j.requests++
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": j.keys})
+}
+
+func encodeJWTPart(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	input := encodeJWTPart(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func testClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"customers"},
+		"sub":   "user-1",
+		"tid":   "tenant-1",
+		"scope": "customers.read",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	hmacKey := []byte("test-secret")
+
+	jwks := &testJWKS{}
+	jwks.set(
+		rsaJWK("rsa-1", rsaKey),
+		map[string]string{
+			"kty": "EC",
+			"kid": "ec-1",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		},
+		map[string]string{
+			"kty": "oct",
+			"kid": "hmac-1",
+			"k":   base64.RawURLEncoding.EncodeToString(hmacKey),
+		},
+	)
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		JWKSURL:  server.URL,
+		Issuer:   "https://issuer.example.com",
+		Audience: "customers",
+		Realm:    "customers",
+	})
+	require.NoError(t, err, "Should not get error creating the authenticator")
+
+	var claims *Claims
+	var tenant string
+	handler := authenticator.Middleware(RequireScopes("customers.read")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		claims = GetClaims(req.Context())
+		tenant = GetTenant(req.Context())
+	})))
+
+	tt := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"RS256", signTestJWT(t, "RS256", "rsa-1", rsaKey, testClaims(nil)), http.StatusOK},
+		{"ES256", signTestJWT(t, "ES256", "ec-1", ecKey, testClaims(nil)), http.StatusOK},
+		{"HS256", signTestJWT(t, "HS256", "hmac-1", hmacKey, testClaims(nil)), http.StatusOK},
+		{"Missing token", "", http.StatusUnauthorized},
+		{"Expired", signTestJWT(t, "RS256", "rsa-1", rsaKey, testClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), http.StatusUnauthorized},
+		{"Within clock skew", signTestJWT(t, "RS256", "rsa-1", rsaKey, testClaims(map[string]interface{}{"exp": time.Now().Add(-time.Second * 30).Unix()})), http.StatusOK},
+		{"Wrong issuer", signTestJWT(t, "RS256", "rsa-1", rsaKey, testClaims(map[string]interface{}{"iss": "https://other.example.com"})), http.StatusUnauthorized},
+		{"Wrong audience", signTestJWT(t, "RS256", "rsa-1", rsaKey, testClaims(map[string]interface{}{"aud": "orders"})), http.StatusUnauthorized},
+		{"Algorithm confusion", signTestJWT(t, "HS256", "rsa-1", rsaKey.N.Bytes(), testClaims(nil)), http.StatusUnauthorized},
+		{"Missing scope", signTestJWT(t, "RS256", "rsa-1", rsaKey, testClaims(map[string]interface{}{"scope": "orders.read"})), http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, tenant = nil, ""
+
+			req, err := http.NewRequest("GET", "/customers", nil)
+			require.NoError(t, err, "Should not get error when creating a request")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code, "Should get the correct status")
+
+			switch tc.status {
+			case http.StatusOK:
+				require.NotNil(t, claims, "Should add the claims to the context")
+				assert.Equal(t, "user-1", claims.Subject)
+				assert.Equal(t, "tenant-1", tenant, "Should add the tenant to the context")
+			case http.StatusUnauthorized:
+				assert.Contains(t, rr.Header().Get("WWW-Authenticate"), `Bearer realm="customers"`)
+				assert.Equal(t, problemContentType, rr.Header().Get("Content-Type"))
+			case http.StatusForbidden:
+				assert.Contains(t, rr.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorKeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := &testJWKS{}
+	jwks.set(rsaJWK("old", oldKey))
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{JWKSURL: server.URL})
+	require.NoError(t, err, "Should not get error creating the authenticator")
+
+	_, err = authenticator.verify(context.Background(), signTestJWT(t, "RS256", "old", oldKey, testClaims(nil)))
+	require.NoError(t, err, "Should verify with the old key")
+
+	_, err = authenticator.verify(context.Background(), signTestJWT(t, "RS256", "old", oldKey, testClaims(nil)))
+	require.NoError(t, err, "Should verify with the cached key")
+	assert.Equal(t, 1, jwks.requests, "Should cache the key set")
+
+	// Allow an immediate refresh for the unknown key
+	jwks.set(rsaJWK("old", oldKey), rsaJWK("new", newKey))
+	authenticator.attemptedAt = time.Time{}
+
+	_, err = authenticator.verify(context.Background(), signTestJWT(t, "RS256", "new", newKey, testClaims(nil)))
+	require.NoError(t, err, "Should refresh the key set for the rotated key")
+	assert.Equal(t, 2, jwks.requests)
+
+	_, err = authenticator.verify(context.Background(), signTestJWT(t, "RS256", "unknown", newKey, testClaims(nil)))
+	assert.Equal(t, errUnknownSigningKey, err)
+	assert.Equal(t, 2, jwks.requests, "Should not refresh the key set for every unknown key")
+}
+
+func TestJWTAuthenticatorRefreshNotBoundToRequest(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := &testJWKS{}
+	jwks.set(rsaJWK("kid", key))
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		jwks.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{JWKSURL: server.URL})
+	require.NoError(t, err, "Should not get error creating the authenticator")
+
+	token := signTestJWT(t, "RS256", "kid", key, testClaims(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = authenticator.verify(ctx, token)
+	assert.Equal(t, context.DeadlineExceeded, err, "Should stop waiting when the request is cancelled")
+
+	close(release)
+
+	_, err = authenticator.verify(context.Background(), token)
+	require.NoError(t, err, "Should verify once the running refresh completes")
+
+	jwks.Lock()
+	defer jwks.Unlock()
+	assert.Equal(t, 1, jwks.requests, "Should share the refresh with the cancelled request")
+}
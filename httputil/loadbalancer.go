@@ -0,0 +1,271 @@
+package httputil
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/discovery"
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultEjectionThreshold = 5
+	defaultEjectionDuration  = time.Second * 30
+
+	endpointField = "endpoint"
+
+	tlsServerNameContextKey = contextKey("tlsServerName")
+)
+
+// BalancingPolicy selects the endpoint for a request
+type BalancingPolicy string
+
+const (
+	// RoundRobin sends the requests to the endpoints in turn
+	RoundRobin BalancingPolicy = "RoundRobin"
+
+	// LeastOutstanding sends the request to the endpoint with the least requests in flight
+	LeastOutstanding BalancingPolicy = "LeastOutstanding"
+)
+
+// LoadBalancingTransport implements http.RoundTripper.
+// When set as Transport of http.Client, it resolves the request host as a logical service
+// name, e.g. http://customers/, and sends the request to one of its endpoints. Hosts the
+// resolver does not know are sent unchanged.
+// The Host header keeps the service name. For https the server certificate must be verified against
+// the service name rather than the endpoint, the transport of NewHTTPClientWithConfig does it,
+// a custom Transport has to dial TLS with the name of the request context.
+type LoadBalancingTransport struct {
+	Transport http.RoundTripper
+	Resolver  discovery.Resolver
+	Policy    BalancingPolicy
+
+	// EjectionThreshold is the number of consecutive failures before an endpoint is ejected
+	EjectionThreshold int
+
+	// EjectionDuration is how long an ejected endpoint receives no requests
+	EjectionDuration time.Duration
+
+	mu sync.Mutex
+
+	// endpoints holds the state of the resolved endpoints by service
+	endpoints map[string]map[string]*endpointState
+	next      map[string]int
+}
+
+// endpointState tracks the requests in flight and the failures of an endpoint
+type endpointState struct {
+	outstanding         int
+	consecutiveFailures int
+	ejectedUntil        time.Time
+}
+
+// RoundTrip implements http.RoundTripper and balances the client requests across the service endpoints
+func (t *LoadBalancingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	service := req.URL.Hostname()
+
+	// Only resolve logical names, not addresses with a port
+	if req.URL.Port() != "" || net.ParseIP(service) != nil {
+		return t.transport().RoundTrip(req)
+	}
+
+	addresses, err := t.Resolver.Resolve(ctx, service)
+	if err == discovery.ErrServiceNotFound {
+		return t.transport().RoundTrip(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, state := t.pick(service, addresses)
+	AddOutgoingLogField(req, endpointField, endpoint)
+
+	// The endpoint is dialed, the connections are pooled by endpoint and verified against the service name
+	outReq := req.Clone(context.WithValue(ctx, tlsServerNameContextKey, service))
+	outReq.URL.Host = endpoint
+	if outReq.Host == "" {
+		outReq.Host = req.URL.Host
+	}
+
+	resp, err := t.transport().RoundTrip(outReq)
+
+	t.done(ctx, service, endpoint, state, resp, err)
+
+	if err != nil {
+		t.release(state)
+		return nil, err
+	}
+
+	// A pooled connection to the endpoint may have been verified for another service with the same endpoint
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		if err := resp.TLS.PeerCertificates[0].VerifyHostname(service); err != nil {
+			resp.Body.Close()
+			t.release(state)
+			return nil, err
+		}
+	}
+
+	// The request is in flight until its body is closed, e.g. for streaming responses
+	resp.Body = &releasingReadCloser{
+		ReadCloser: resp.Body,
+		release:    func() { t.release(state) },
+	}
+
+	return resp, nil
+}
+
+func (t *LoadBalancingTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+
+	return http.DefaultTransport
+}
+
+// pick selects the endpoint for the request and marks it as having a request in flight
+func (t *LoadBalancingTransport) pick(service string, addresses []string) (string, *endpointState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.endpoints == nil {
+		t.endpoints = make(map[string]map[string]*endpointState)
+		t.next = make(map[string]int)
+	}
+
+	states, ok := t.endpoints[service]
+	if !ok {
+		states = make(map[string]*endpointState)
+		t.endpoints[service] = states
+	}
+
+	// Forget the endpoints the resolver no longer returns
+	for address := range states {
+		if !containsString(addresses, address) {
+			delete(states, address)
+		}
+	}
+
+	state := func(address string) *endpointState {
+		s, ok := states[address]
+		if !ok {
+			s = &endpointState{}
+			states[address] = s
+		}
+		return s
+	}
+
+	now := time.Now()
+	available := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if now.Before(state(address).ejectedUntil) {
+			continue
+		}
+		available = append(available, address)
+	}
+
+	// Use all endpoints if every one of them is ejected
+	if len(available) == 0 {
+		available = addresses
+	}
+
+	var endpoint string
+	switch t.Policy {
+	case LeastOutstanding:
+		endpoint = available[0]
+		for _, address := range available[1:] {
+			if state(address).outstanding < state(endpoint).outstanding {
+				endpoint = address
+			}
+		}
+	default:
+		endpoint = available[t.next[service]%len(available)]
+		t.next[service]++
+	}
+
+	state(endpoint).outstanding++
+
+	return endpoint, states[endpoint]
+}
+
+// release marks the request of the endpoint as no longer in flight
+func (t *LoadBalancingTransport) release(state *endpointState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state.outstanding--
+}
+
+// done records the outcome of the request and ejects the endpoint after too many failures
+func (t *LoadBalancingTransport) done(ctx context.Context, service, endpoint string, state *endpointState, resp *http.Response, err error) {
+	// The caller cancelling the request or its deadline expiring says nothing about the endpoint
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failed := err != nil ||
+		resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable ||
+		resp.StatusCode == http.StatusGatewayTimeout
+
+	if !failed {
+		state.consecutiveFailures = 0
+		return
+	}
+
+	state.consecutiveFailures++
+	if state.consecutiveFailures < t.ejectionThreshold() {
+		return
+	}
+
+	state.consecutiveFailures = 0
+	state.ejectedUntil = time.Now().Add(t.ejectionDuration())
+
+	log.G(ctx).WithFields(log.Fields{
+		"service":         service,
+		endpointField:     endpoint,
+		"ejectedDuration": t.ejectionDuration(),
+	}).Warn("Ejected failing endpoint")
+}
+
+func (t *LoadBalancingTransport) ejectionThreshold() int {
+	if t.EjectionThreshold > 0 {
+		return t.EjectionThreshold
+	}
+
+	return defaultEjectionThreshold
+}
+
+func (t *LoadBalancingTransport) ejectionDuration() time.Duration {
+	if t.EjectionDuration > 0 {
+		return t.EjectionDuration
+	}
+
+	return defaultEjectionDuration
+}
+
+// getTLSServerName gets the service name of a load balanced request from a context
+func getTLSServerName(ctx context.Context) string {
+	name, _ := ctx.Value(tlsServerNameContextKey).(string)
+	return name
+}
+
+// releasingReadCloser calls release once when the body is closed
+type releasingReadCloser struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
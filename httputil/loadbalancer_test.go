@@ -0,0 +1,194 @@
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samkreter/go-core/discovery"
+)
+
+func newTestEndpoint(name string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(status)
+		rw.Write([]byte(name))
+	}))
+}
+
+func TestLoadBalancingRoundRobin(t *testing.T) {
+	first := newTestEndpoint("first", 200)
+	defer first.Close()
+	second := newTestEndpoint("second", 200)
+	defer second.Close()
+
+	firstAddr := strings.TrimPrefix(first.URL, "http://")
+	secondAddr := strings.TrimPrefix(second.URL, "http://")
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := NewHTTPClientWithConfig(&ClientConfig{
+		LoggingEnabled: true,
+		Resolver: discovery.StaticResolver{
+			"customers": {firstAddr, secondAddr},
+		},
+		BalancingPolicy: RoundRobin,
+	})
+
+	var endpoints []string
+	for i := 0; i < 4; i++ {
+		resp, err := c.Get("http://customers/customer")
+		require.NoError(t, err, "Should not get error for server response")
+		resp.Body.Close()
+
+		endpoints = append(endpoints, getValueFromLog(*testHook.LastEntry(), endpointField, t))
+	}
+
+	assert.Equal(t, []string{firstAddr, secondAddr, firstAddr, secondAddr}, endpoints, "Should log the endpoints in turn")
+}
+
+func TestLoadBalancingEjection(t *testing.T) {
+	healthy := newTestEndpoint("healthy", 200)
+	defer healthy.Close()
+	failing := newTestEndpoint("failing", 503)
+	defer failing.Close()
+
+	healthyAddr := strings.TrimPrefix(healthy.URL, "http://")
+	failingAddr := strings.TrimPrefix(failing.URL, "http://")
+
+	transport := &LoadBalancingTransport{
+		Resolver: discovery.StaticResolver{
+			"customers": {healthyAddr, failingAddr},
+		},
+		Policy:            RoundRobin,
+		EjectionThreshold: 1,
+	}
+
+	c := &http.Client{Transport: transport}
+
+	statuses := map[int]int{}
+	for i := 0; i < 6; i++ {
+		resp, err := c.Get("http://customers/customer")
+		require.NoError(t, err, "Should not get error for server response")
+		resp.Body.Close()
+
+		statuses[resp.StatusCode]++
+	}
+
+	assert.Equal(t, 1, statuses[503], "Should eject the failing endpoint after the first failure")
+	assert.Equal(t, 5, statuses[200], "Should send the other requests to the healthy endpoint")
+}
+
+func TestLoadBalancingUnknownHost(t *testing.T) {
+	server := newTestEndpoint("server", 200)
+	defer server.Close()
+
+	c := &http.Client{
+		Transport: &LoadBalancingTransport{
+			Resolver: discovery.StaticResolver{},
+		},
+	}
+
+	resp, err := c.Get(server.URL)
+	require.NoError(t, err, "Should send the request unchanged")
+	resp.Body.Close()
+
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestLoadBalancingEndpointState(t *testing.T) {
+	first := newTestEndpoint("first", 200)
+	defer first.Close()
+	second := newTestEndpoint("second", 200)
+	defer second.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer slow.Close()
+
+	firstAddr := strings.TrimPrefix(first.URL, "http://")
+	secondAddr := strings.TrimPrefix(second.URL, "http://")
+	slowAddr := strings.TrimPrefix(slow.URL, "http://")
+
+	resolver := discovery.StaticResolver{
+		"customers": {firstAddr, secondAddr},
+		"slow":      {slowAddr},
+	}
+	transport := &LoadBalancingTransport{
+		Resolver:          resolver,
+		Policy:            LeastOutstanding,
+		EjectionThreshold: 1,
+	}
+
+	get := func(ctx context.Context, url string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		return transport.RoundTrip(req.WithContext(ctx))
+	}
+
+	streaming, err := get(context.Background(), "http://customers/customer")
+	require.NoError(t, err)
+	assert.Equal(t, 1, transport.endpoints["customers"][firstAddr].outstanding, "Should count the request until the body is closed")
+
+	resp, err := get(context.Background(), "http://customers/customer")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 0, transport.endpoints["customers"][secondAddr].outstanding, "Should send the request to the endpoint without a streaming response")
+
+	streaming.Body.Close()
+	streaming.Body.Close()
+	assert.Equal(t, 0, transport.endpoints["customers"][firstAddr].outstanding, "Should release the request once")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = get(ctx, "http://slow/customer")
+	require.Error(t, err)
+	assert.False(t, time.Now().Before(transport.endpoints["slow"][slowAddr].ejectedUntil), "Should not eject the endpoint for a cancelled request")
+	assert.Equal(t, 0, transport.endpoints["slow"][slowAddr].outstanding)
+
+	resolver["customers"] = []string{secondAddr}
+	resp, err = get(context.Background(), "http://customers/customer")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.NotContains(t, transport.endpoints["customers"], firstAddr, "Should forget the removed endpoints")
+}
+
+func TestLoadBalancingTLSServerName(t *testing.T) {
+	// The test certificate is issued for example.com and 127.0.0.1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Host))
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	c := NewHTTPClientWithConfig(&ClientConfig{
+		TLSConfig: &tls.Config{RootCAs: roots},
+		Resolver: discovery.StaticResolver{
+			"example.com": {address},
+			"customers":   {address},
+		},
+	})
+
+	resp, err := c.Get("https://example.com/")
+	require.NoError(t, err, "Should verify the endpoint certificate against the service name")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "example.com", string(body), "Should keep the service name in the Host header")
+
+	_, err = c.Get("https://customers/")
+	assert.Error(t, err, "Should not verify the endpoint certificate against the endpoint address")
+}
@@ -0,0 +1,35 @@
+package httputil
+
+import (
+	"sync"
+
+	"github.com/samkreter/go-core/log"
+)
+
+// logFields allows for thread safe access to the extra fields of a request log
+type logFields struct {
+	sync.Mutex
+	fields log.Fields
+}
+
+func newLogFields() *logFields {
+	return &logFields{
+		fields: log.Fields{},
+	}
+}
+
+func (f *logFields) set(key string, value interface{}) {
+	f.Lock()
+	defer f.Unlock()
+	f.fields[key] = value
+}
+
+// addTo copies the extra fields into the log fields
+func (f *logFields) addTo(fields log.Fields) {
+	f.Lock()
+	defer f.Unlock()
+
+	for k, v := range f.fields {
+		fields[k] = v
+	}
+}
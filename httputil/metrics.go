@@ -0,0 +1,57 @@
+package httputil
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// The following tags are applied to the server measures
+var (
+	// KeyServerRouteClass is the class of routes sharing a concurrency limit
+	KeyServerRouteClass, _ = tag.NewKey("http_server_route_class")
+)
+
+// The following server measures are recorded by the httputil middlewares
+var (
+	ServerPanicCount = stats.Int64(
+		"github.com/samkreter/go-core/httputil/server/panics",
+		"Number of panics recovered from the handlers",
+		stats.UnitDimensionless)
+
+	ServerConcurrencyLimit = stats.Int64(
+		"github.com/samkreter/go-core/httputil/server/concurrency_limit",
+		"Current limit of requests in flight",
+		stats.UnitDimensionless)
+
+	ServerShedCount = stats.Int64(
+		"github.com/samkreter/go-core/httputil/server/shed",
+		"Number of requests rejected by the concurrency limit",
+		stats.UnitDimensionless)
+)
+
+// The following views are provided for the server measures, they must be registered to be exported
+var (
+	ServerPanicCountView = &view.View{
+		Name:        "github.com/samkreter/go-core/httputil/server/panics",
+		Description: "Count of panics recovered from the handlers",
+		Measure:     ServerPanicCount,
+		Aggregation: view.Count(),
+	}
+
+	ServerConcurrencyLimitView = &view.View{
+		Name:        "github.com/samkreter/go-core/httputil/server/concurrency_limit",
+		Description: "Current limit of requests in flight by route class",
+		TagKeys:     []tag.Key{KeyServerRouteClass},
+		Measure:     ServerConcurrencyLimit,
+		Aggregation: view.LastValue(),
+	}
+
+	ServerShedCountView = &view.View{
+		Name:        "github.com/samkreter/go-core/httputil/server/shed",
+		Description: "Count of requests rejected by the concurrency limit by route class",
+		TagKeys:     []tag.Key{KeyServerRouteClass},
+		Measure:     ServerShedCount,
+		Aggregation: view.Count(),
+	}
+)
@@ -2,56 +2,98 @@ package httputil
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/samkreter/go-core/correlation"
-	"github.com/samkreter/go-core/log"
 
-	"github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/trace"
 )
 
+// ExcludedPaths are not logged or traced by the middlewares, they default to the health endpoints
+// which are called frequently by the orchestrator
+var ExcludedPaths = []string{"/healthz", "/readyz"}
+
 const (
 	incomingRequestStart = "HttpIncomingRequestStart"
 	incomingRequestEnd   = "HttpIncomingRequestStart"
 )
 
-// HandlerConfig holds configuration for which middle to enable
+// HandlerConfig holds configuration for which middle to enable.
+// It is a preset of the Chain with the httputil middlewares, use a Chain to add other middlewares.
 type HandlerConfig struct {
 	CorrelationEnabled bool
 	LoggingEnabled     bool
 	TracingEnabled     bool
+	RecoveryEnabled    bool
+
+	// PeerIdentityEnabled adds the identity of the verified client certificate to the context and logs
+	PeerIdentityEnabled bool
+
+	// ClientIPResolver resolves the client behind the trusted proxies for the other middlewares when set
+	ClientIPResolver *ClientIPResolver
+
+	// AccessLogger replaces the default incoming request logging when set
+	AccessLogger *AccessLogger
 }
 
-// SetUpHandler adds logging, tracing and correlation for incoming requests
-func SetUpHandler(handler http.Handler, config *HandlerConfig) http.Handler {
+// Chain returns the chain of the enabled middlewares
+func (config *HandlerConfig) Chain() *Chain {
+	chain := NewChain()
 
-	// Adding distributed tracing
-	if config.TracingEnabled {
-		handler = TracingMiddleware(handler)
+	if config.ClientIPResolver != nil {
+		chain = chain.With(config.ClientIPResolver.ChainMiddleware())
+	}
+
+	if config.CorrelationEnabled {
+		chain = chain.With(CorrelationChainMiddleware)
 	}
 
-	// Add incoming request logging
 	if config.LoggingEnabled {
-		handler = IncomingRequestLoggingMiddleware(handler)
+		logging := LoggingChainMiddleware
+		if config.AccessLogger != nil {
+			logging.Middleware = config.AccessLogger.Middleware
+		}
+		chain = chain.With(logging)
 	}
 
-	// Add correlation propogation
-	// Note(sakreter) this must be the last handler returned to ensure the correlation
-	// information is in the context for the following handlers
-	if config.CorrelationEnabled {
-		handler = CorrelationMiddleware(handler)
+	if config.PeerIdentityEnabled {
+		chain = chain.With(PeerIdentityChainMiddleware)
+	}
+
+	if config.TracingEnabled {
+		chain = chain.With(TracingChainMiddleware)
 	}
 
-	return handler
+	if config.RecoveryEnabled {
+		chain = chain.With(RecoveryChainMiddleware)
+	}
+
+	return chain
+}
+
+// SetUpHandler adds logging, tracing and correlation for incoming requests
+func SetUpHandler(handler http.Handler, config *HandlerConfig) http.Handler {
+	// The preset order is always valid, logging without correlation is allowed for compatibility
+	return config.Chain().then(handler)
 }
 
 // TracingMiddleware adds tracing Middleware to the handler
 func TracingMiddleware(handler http.Handler) http.Handler {
-	return &ochttp.Handler{
-		Handler:     handler,
-		Propagation: &b3.HTTPFormat{}}
+	tracingHandler := &ochttp.Handler{
+		Handler:         addClientSpanAttributes(handler),
+		Propagation:     &b3.HTTPFormat{},
+		GetStartOptions: debugStartOptions,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isExcludedPath(req) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		tracingHandler.ServeHTTP(w, req)
+	})
 }
 
 // CorrelationMiddleware adds correlation Middleware to the handler
@@ -64,61 +106,31 @@ func CorrelationMiddleware(next http.Handler) http.Handler {
 }
 
 // IncomingRequestLoggingMiddleware add incoming request logging to the handler
-// TODO(sakreter): add support for operationName and apiVersion
+// Note: the operationName and apiVersion are added by the OperationResolver middleware of the router
 func IncomingRequestLoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		ctx := req.Context()
-
-		fields := logrus.Fields{
-			"httpMethod":    req.Method,
-			"targetUri":     req.URL.String(),
-			"hostName":      req.Host,
-			"correlationID": correlation.GetCorrelationID(ctx),
-			"activityID":    correlation.GetActivityID(ctx),
-			"taskName":      "StartIncomingRequest",
-		}
-
-		contentType := req.Header.Get("Content-Type")
-		if contentType != "" {
-			fields["contentType"] = contentType
-		}
-
-		rr := &responseRecorder{w: w}
-
-		log.G(ctx).WithFields(fields).Info("Incoming request Start")
-
-		startTime := time.Now()
-
-		defer func() {
-			fields["contentLength"] = rr.contentLength
-			fields["httpStatusCode"] = rr.statusCode
-			fields["durationInMilliseconds"] = time.Now().Sub(startTime)
+	return defaultAccessLogger.Middleware(next)
+}
 
-			log.G(ctx).WithFields(fields).Info("Incoming request End")
-		}()
+// debugStartOptions samples the requests with a debug escalation, see correlation.DebugHeader
+func debugStartOptions(req *http.Request) trace.StartOptions {
+	if _, ok := correlation.GetDebugLevel(req.Context()); ok {
+		return trace.StartOptions{Sampler: trace.AlwaysSample()}
+	}
 
-		next.ServeHTTP(rr, req.WithContext(ctx))
-	})
+	return trace.StartOptions{}
 }
 
-type responseRecorder struct {
-	contentLength int
-	statusCode    int
-	w             http.ResponseWriter
+// isExcludedPath returns true when the request path is in the ExcludedPaths
+func isExcludedPath(req *http.Request) bool {
+	return containsString(ExcludedPaths, req.URL.Path)
 }
 
-func (r *responseRecorder) Header() http.Header { return r.w.Header() }
+type incomingLogFieldsKey struct{}
 
-func (r *responseRecorder) Write(p []byte) (int, error) {
-	if r.statusCode == 0 {
-		r.statusCode = http.StatusOK
+// AddIncomingLogField adds a field to the "Incoming request End" log of the request.
+// Only has an effect for handlers wrapped by IncomingRequestLoggingMiddleware.
+func AddIncomingLogField(req *http.Request, key string, value interface{}) {
+	if extra, ok := req.Context().Value(incomingLogFieldsKey{}).(*logFields); ok {
+		extra.set(key, value)
 	}
-	n, err := r.w.Write(p)
-	r.contentLength += n
-	return n, err
-}
-
-func (r *responseRecorder) WriteHeader(statusCode int) {
-	r.statusCode = statusCode
-	r.w.WriteHeader(statusCode)
 }
@@ -0,0 +1,293 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultTokenExpiryDelta = time.Minute
+	maxTokenErrorBodySize   = 1024
+
+	// tokenFetchTimeout bounds the shared token request, it is not bound to a caller's context
+	tokenFetchTimeout = time.Second * 30
+)
+
+// TokenSource provides bearer tokens for the AuthTransport
+type TokenSource interface {
+	// Token returns a valid token, fetching a new one if needed
+	Token(ctx context.Context) (string, error)
+
+	// Invalidate drops the token so the next call to Token fetches a new one
+	Invalidate(token string)
+}
+
+// ClientCredentialsConfig configuration for the OAuth2 client credentials grant
+type ClientCredentialsConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// ExpiryDelta is how long before the expiry the token is refreshed,
+	// at most half the lifetime of the token so short lived tokens are still cached
+	ExpiryDelta time.Duration
+
+	// HTTPClient is the client used for the token requests
+	HTTPClient *http.Client
+}
+
+// ClientCredentialsTokenSource fetches and caches tokens using the OAuth2 client credentials grant.
+// Concurrent callers share a single token request.
+type ClientCredentialsTokenSource struct {
+	config ClientCredentialsConfig
+
+	sync.Mutex
+	token     string
+	refreshAt time.Time
+	inflight  *tokenCall
+}
+
+// tokenCall is a token request shared by all the callers waiting for it
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// NewClientCredentialsTokenSource creates a new client credentials token source
+func NewClientCredentialsTokenSource(config ClientCredentialsConfig) (*ClientCredentialsTokenSource, error) {
+	if config.TokenURL == "" {
+		return nil, fmt.Errorf("tokenURL can not be empty")
+	}
+
+	if config.ClientID == "" {
+		return nil, fmt.Errorf("clientID can not be empty")
+	}
+
+	if config.ExpiryDelta == 0 {
+		config.ExpiryDelta = defaultTokenExpiryDelta
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{
+			Timeout: defaultHTTPClientTimeout,
+		}
+	}
+
+	return &ClientCredentialsTokenSource{
+		config: config,
+	}, nil
+}
+
+// Token returns the cached token or fetches a new one when it is about to expire
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (string, error) {
+	s.Lock()
+	if s.valid() {
+		token := s.token
+		s.Unlock()
+		return token, nil
+	}
+
+	call := s.inflight
+	if call == nil {
+		call = &tokenCall{done: make(chan struct{})}
+		s.inflight = call
+
+		// The request is not bound to the caller's context, other callers may be waiting on it
+		go s.fetch(log.WithLogger(context.Background(), log.G(ctx)), call)
+	}
+	s.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invalidate drops the token if it is still the cached one
+func (s *ClientCredentialsTokenSource) Invalidate(token string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// valid must be called with the lock held
+func (s *ClientCredentialsTokenSource) valid() bool {
+	if s.token == "" {
+		return false
+	}
+
+	return s.refreshAt.IsZero() || time.Now().Before(s.refreshAt)
+}
+
+func (s *ClientCredentialsTokenSource) fetch(ctx context.Context, call *tokenCall) {
+	ctx, cancel := context.WithTimeout(ctx, tokenFetchTimeout)
+	defer cancel()
+
+	start := time.Now()
+	token, expiry, err := s.requestToken(ctx)
+
+	s.Lock()
+	if err == nil {
+		s.token = token
+		s.refreshAt = tokenRefreshAt(start, expiry, s.config.ExpiryDelta)
+	}
+	s.inflight = nil
+	s.Unlock()
+
+	call.token = token
+	call.err = err
+	close(call.done)
+}
+
+// tokenRefreshAt returns when to refresh a token fetched at start, zero when it does not expire.
+// The delta is clamped to half the lifetime of the token.
+func tokenRefreshAt(start, expiry time.Time, delta time.Duration) time.Time {
+	if expiry.IsZero() {
+		return time.Time{}
+	}
+
+	if lifetime := expiry.Sub(start); delta > lifetime/2 {
+		delta = lifetime / 2
+	}
+
+	return expiry.Add(-delta)
+}
+
+func (s *ClientCredentialsTokenSource) requestToken(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+	}
+
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+
+	resp, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenErrorBodySize))
+		return "", time.Time{}, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(b))
+	}
+
+	var tokenResp tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse token response: %v", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response has no access_token")
+	}
+
+	var expiry time.Time
+	if tokenResp.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
+
+	log.G(ctx).WithFields(log.Fields{
+		"tokenUri":  s.config.TokenURL,
+		"expiresIn": tokenResp.ExpiresIn,
+	}).Debug("Fetched client credentials token")
+
+	return tokenResp.AccessToken, expiry, nil
+}
+
+// AuthTransport implements http.RoundTripper.
+// When set as Transport of http.Client, it adds a bearer token to the requests.
+// A request rejected with 401 is retried once with a fresh token.
+type AuthTransport struct {
+	Transport   http.RoundTripper
+	TokenSource TokenSource
+}
+
+// RoundTrip implements http.RoundTripper and adds the bearer token to the client requests
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.TokenSource.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.transport().RoundTrip(withBearerToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The body was already sent and can't be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	log.G(req.Context()).Debug("Request unauthorized, retrying with a new token")
+
+	t.TokenSource.Invalidate(token)
+	token, err = t.TokenSource.Token(req.Context())
+	if err != nil {
+		// Return the original unauthorized response
+		return resp, nil
+	}
+
+	retryReq := withBearerToken(req, token)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retryReq.Body = body
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return t.transport().RoundTrip(retryReq)
+}
+
+func (t *AuthTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+
+	return http.DefaultTransport
+}
+
+// withBearerToken returns a copy of the request with the authorization header set,
+// a RoundTripper must not modify the passed in request
+func withBearerToken(req *http.Request, token string) *http.Request {
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", "Bearer "+token)
+	return authReq
+}
@@ -0,0 +1,146 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTokenServer(t *testing.T, tokenRequests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(tokenRequests, 1)
+
+		clientID, clientSecret, ok := req.BasicAuth()
+		assert.True(t, ok, "Should use basic auth for the client credentials")
+		assert.Equal(t, "test-client", clientID)
+		assert.Equal(t, "test-secret", clientSecret)
+		assert.Equal(t, "client_credentials", req.FormValue("grant_type"))
+
+		fmt.Fprintf(rw, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, n)
+	}))
+}
+
+func newTestTokenSource(t *testing.T, tokenURL string) *ClientCredentialsTokenSource {
+	source, err := NewClientCredentialsTokenSource(ClientCredentialsConfig{
+		TokenURL:     tokenURL,
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+	})
+	require.NoError(t, err, "Should not get error creating the token source")
+
+	return source
+}
+
+func TestAuthTransportCachesToken(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := newTestTokenServer(t, &tokenRequests)
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Bearer token-1", req.Header.Get("Authorization"), "Should send the cached token")
+		rw.Write([]byte(`OK`))
+	}))
+	defer server.Close()
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := NewHTTPClientWithConfig(&ClientConfig{
+		LoggingEnabled: true,
+		TokenSource:    newTestTokenSource(t, tokenServer.URL),
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(server.URL)
+			require.NoError(t, err, "Should not get error for server response")
+			resp.Body.Close()
+			assert.Equal(t, 200, resp.StatusCode, "Should get OK status code.")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests), "Concurrent requests should share one token request")
+
+	for _, entry := range testHook.AllEntries() {
+		line, err := entry.String()
+		require.NoError(t, err)
+		assert.NotContains(t, line, "token-1", "Should never log the token")
+	}
+}
+
+func TestAuthTransportRetriesUnauthorized(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := newTestTokenServer(t, &tokenRequests)
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token-2" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(req.Body)
+		rw.Write(b)
+	}))
+	defer server.Close()
+
+	source := newTestTokenSource(t, tokenServer.URL)
+	c := NewHTTPClientWithConfig(&ClientConfig{
+		TokenSource: source,
+	})
+
+	resp, err := c.Post(server.URL, "text/plain", bytes.NewBufferString("body"))
+	require.NoError(t, err, "Should not get error for server response")
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode, "Should succeed with the fresh token")
+	assert.Equal(t, "body", string(b), "Should resend the body")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&tokenRequests), "Should fetch a fresh token after the 401")
+
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", token, "Should cache the fresh token")
+}
+
+func TestClientCredentialsShortLivedToken(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&tokenRequests, 1)
+		fmt.Fprintf(rw, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 30}`, n)
+	}))
+	defer tokenServer.Close()
+
+	source := newTestTokenSource(t, tokenServer.URL)
+
+	for i := 0; i < 3; i++ {
+		token, err := source.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token-1", token, "Should cache a token living less than the expiry delta")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests))
+
+	start := time.Now()
+	assert.Equal(t, start.Add(time.Minute*45), tokenRefreshAt(start, start.Add(time.Hour), time.Minute*15))
+	assert.Equal(t, start.Add(time.Second*15), tokenRefreshAt(start, start.Add(time.Second*30), time.Minute),
+		"Should clamp the delta to half the lifetime")
+	assert.True(t, tokenRefreshAt(start, time.Time{}, time.Minute).IsZero())
+}
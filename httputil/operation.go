@@ -0,0 +1,165 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/samkreter/go-core/log"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+const (
+	operationNameContextKey = contextKey("operationName")
+	apiVersionContextKey    = contextKey("apiVersion")
+
+	defaultAPIVersionParameter    = "api-version"
+	defaultAPIVersionPathVariable = "apiVersion"
+
+	operationNameAttribute = "http.operation_name"
+	apiVersionAttribute    = "http.api_version"
+)
+
+// OperationConfig holds the configuration for the OperationResolver
+type OperationConfig struct {
+	// VersionParameter is the query parameter and header holding the API version, defaults to "api-version"
+	VersionParameter string
+
+	// VersionPathVariable is the mux route variable holding the API version, defaults to "apiVersion"
+	VersionPathVariable string
+
+	// SupportedVersions holds the API versions supported by each operation.
+	// Operations not in the map accept any version, including none.
+	SupportedVersions map[string][]string
+}
+
+// OperationResolver names the incoming requests after the matched mux route and extracts their API version
+type OperationResolver struct {
+	versionParameter    string
+	versionPathVariable string
+	supportedVersions   map[string][]string
+}
+
+// NewOperationResolver creates a new OperationResolver from the config
+func NewOperationResolver(config OperationConfig) (*OperationResolver, error) {
+	if config.VersionParameter == "" {
+		config.VersionParameter = defaultAPIVersionParameter
+	}
+
+	if config.VersionPathVariable == "" {
+		config.VersionPathVariable = defaultAPIVersionPathVariable
+	}
+
+	for operation, versions := range config.SupportedVersions {
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("operation %q must support at least one version", operation)
+		}
+	}
+
+	return &OperationResolver{
+		versionParameter:    config.VersionParameter,
+		versionPathVariable: config.VersionPathVariable,
+		supportedVersions:   config.SupportedVersions,
+	}, nil
+}
+
+// Middleware adds the operation name and API version to the request context, logs, span and metrics.
+// It must be added to the router with Router.Use so the route is matched before it runs.
+func (r *OperationResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		operationName := OperationName(req)
+		apiVersion := r.apiVersion(req)
+
+		if supported, ok := r.supportedVersions[operationName]; ok && !containsString(supported, apiVersion) {
+			detail := fmt.Sprintf("unsupported %s %q, supported versions are %v", r.versionParameter, apiVersion, supported)
+			if apiVersion == "" {
+				detail = fmt.Sprintf("missing %s, supported versions are %v", r.versionParameter, supported)
+			}
+
+			WriteProblem(w, req, NewProblem(http.StatusBadRequest, detail))
+			return
+		}
+
+		ctx = WithOperationName(ctx, operationName)
+		logger := log.G(ctx).WithField("operationName", operationName)
+		AddIncomingLogField(req, "operationName", operationName)
+
+		if apiVersion != "" {
+			ctx = WithAPIVersion(ctx, apiVersion)
+			logger = logger.WithField("apiVersion", apiVersion)
+			AddIncomingLogField(req, "apiVersion", apiVersion)
+		}
+
+		ctx = log.WithLogger(ctx, logger)
+
+		// Use the low cardinality name for the span and the http server metrics
+		ochttp.SetRoute(ctx, operationName)
+		if span := trace.FromContext(ctx); span != nil {
+			span.SetName(operationName)
+			span.AddAttributes(trace.StringAttribute(operationNameAttribute, operationName))
+			if apiVersion != "" {
+				span.AddAttributes(trace.StringAttribute(apiVersionAttribute, apiVersion))
+			}
+		}
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// apiVersion returns the version from the query parameter, the header or the path in that order
+func (r *OperationResolver) apiVersion(req *http.Request) string {
+	if version := req.URL.Query().Get(r.versionParameter); version != "" {
+		return version
+	}
+
+	if version := req.Header.Get(r.versionParameter); version != "" {
+		return version
+	}
+
+	return mux.Vars(req)[r.versionPathVariable]
+}
+
+// OperationName returns the name of the matched mux route, or the method and route
+// template when the route has no name. Falls back to the method and path.
+func OperationName(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if name := route.GetName(); name != "" {
+			return name
+		}
+	}
+
+	return RouteKey(req)
+}
+
+// WithOperationName returns a new context with the operation name of the request
+func WithOperationName(ctx context.Context, operationName string) context.Context {
+	return context.WithValue(ctx, operationNameContextKey, operationName)
+}
+
+// GetOperationName gets the operation name of the request from a context
+func GetOperationName(ctx context.Context) string {
+	operationName, ok := ctx.Value(operationNameContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return operationName
+}
+
+// WithAPIVersion returns a new context with the API version of the request
+func WithAPIVersion(ctx context.Context, apiVersion string) context.Context {
+	return context.WithValue(ctx, apiVersionContextKey, apiVersion)
+}
+
+// GetAPIVersion gets the API version of the request from a context
+func GetAPIVersion(ctx context.Context) string {
+	apiVersion, ok := ctx.Value(apiVersionContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return apiVersion
+}
@@ -0,0 +1,97 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationResolver(t *testing.T) {
+	operations, err := NewOperationResolver(OperationConfig{
+		SupportedVersions: map[string][]string{
+			"GetCustomer": {"2019-01-01", "2020-01-01"},
+		},
+	})
+	require.NoError(t, err, "Should not get error creating the operation resolver")
+
+	var operationName, apiVersion string
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		operationName = GetOperationName(req.Context())
+		apiVersion = GetAPIVersion(req.Context())
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/customers/{id}", handler).Methods("GET").Name("GetCustomer")
+	router.HandleFunc("/{apiVersion}/orders/{id}", handler).Methods("GET")
+	router.Use(operations.Middleware)
+
+	tt := []struct {
+		name          string
+		url           string
+		header        string
+		status        int
+		operationName string
+		apiVersion    string
+	}{
+		{"Named route with query version", "/customers/1?api-version=2020-01-01", "", http.StatusOK, "GetCustomer", "2020-01-01"},
+		{"Named route with header version", "/customers/1", "2019-01-01", http.StatusOK, "GetCustomer", "2019-01-01"},
+		{"Unsupported version", "/customers/1?api-version=2018-01-01", "", http.StatusBadRequest, "", ""},
+		{"Missing version", "/customers/1", "", http.StatusBadRequest, "", ""},
+		{"Route template with path version", "/v1/orders/2", "", http.StatusOK, "GET /{apiVersion}/orders/{id}", "v1"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			operationName, apiVersion = "", ""
+
+			req, err := http.NewRequest("GET", tc.url, nil)
+			require.NoError(t, err, "Should not get error when creating a request")
+			if tc.header != "" {
+				req.Header.Set("api-version", tc.header)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code, "Should get the correct status")
+			assert.Equal(t, tc.operationName, operationName, "Should get the operation name")
+			assert.Equal(t, tc.apiVersion, apiVersion, "Should get the api version")
+		})
+	}
+}
+
+func TestOperationNameLogged(t *testing.T) {
+	operations, err := NewOperationResolver(OperationConfig{})
+	require.NoError(t, err, "Should not get error creating the operation resolver")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/customers/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`OK`))
+	}).Methods("GET")
+	router.Use(operations.Middleware)
+
+	handler := SetUpHandler(router, &HandlerConfig{
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+		TracingEnabled:     true,
+	})
+
+	req, err := http.NewRequest("GET", "/customers/123?api-version=v2", nil)
+	require.NoError(t, err, "Should not get error when creating a request")
+	AddStandardRequestHeaders(req)
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, 2, len(testHook.Entries), "Should have correct number of logs")
+	assert.Equal(t, "GET /customers/{id}", testHook.Entries[1].Data["operationName"], "Should log the route template")
+	assert.Equal(t, "v2", testHook.Entries[1].Data["apiVersion"], "Should log the api version")
+}
@@ -0,0 +1,54 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	problemContentType = "application/problem+json"
+)
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type          string `json:"type,omitempty"`
+	Title         string `json:"title"`
+	Status        int    `json:"status"`
+	Detail        string `json:"detail,omitempty"`
+	Instance      string `json:"instance,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// NewProblem creates a problem with the standard title for the status code
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WriteProblem writes the problem response. The correlation ID of the request is
+// added so callers can reference it when reporting the error.
+func WriteProblem(w http.ResponseWriter, req *http.Request, problem Problem) {
+	ctx := req.Context()
+
+	if problem.Instance == "" {
+		problem.Instance = req.URL.Path
+	}
+
+	if problem.CorrelationID == "" {
+		problem.CorrelationID = correlation.GetCorrelationID(ctx)
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.G(ctx).WithError(err).Error("Failed to write problem response")
+	}
+}
@@ -0,0 +1,283 @@
+package httputil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	tenantContextKey = contextKey("tenant")
+
+	defaultRateLimitStoreSize = 10000
+)
+
+var (
+	// RateLimitLimitHeader the number of requests allowed in the window
+	RateLimitLimitHeader = "RateLimit-Limit"
+
+	// RateLimitRemainingHeader the number of requests left before being throttled
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+
+	// RateLimitResetHeader the seconds until the bucket is full again
+	RateLimitResetHeader = "RateLimit-Reset"
+)
+
+// RateLimit is a token bucket allowing Requests per Period with bursts of up to Burst requests
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+
+	// Burst is the size of the bucket, defaults to Requests
+	Burst int
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return l.Requests
+}
+
+// interval is the time to refill a single token
+func (l RateLimit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// RateLimitResult is the state of the bucket after taking a token
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+
+	// Reset is the time until the bucket is full
+	Reset time.Duration
+
+	// RetryAfter is the time until the next token is available when the request is not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore holds the token buckets for the rate limit keys
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore is a RateLimitStore holding the buckets of the most recently used keys in memory
+type MemoryRateLimitStore struct {
+	sync.Mutex
+	lru *simplelru.LRU
+}
+
+// tokenBucket holds the tokens left at the last update
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemoryRateLimitStore creates a new MemoryRateLimitStore holding at most size keys
+func NewMemoryRateLimitStore(size int) (*MemoryRateLimitStore, error) {
+	lru, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryRateLimitStore{
+		lru: lru,
+	}, nil
+}
+
+// Take takes a token from the bucket of the key
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	burst := float64(limit.burst())
+	interval := limit.interval()
+
+	bucket := &tokenBucket{tokens: burst, updated: now}
+	if value, ok := s.lru.Get(key); ok {
+		bucket = value.(*tokenBucket)
+	}
+
+	// Refill the tokens for the time since the last request
+	bucket.tokens = math.Min(burst, bucket.tokens+float64(now.Sub(bucket.updated))/float64(interval))
+	bucket.updated = now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) * float64(interval))
+	}
+
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((burst - bucket.tokens) * float64(interval))
+
+	s.lru.Add(key, bucket)
+
+	return result, nil
+}
+
+// KeyExtractor returns the rate limit key of the request. Requests with an empty key are not limited.
+type KeyExtractor func(req *http.Request) string
+
+// ClientIPKey uses the client IP resolved by the ClientIPResolver, or the address of the connected client, as the key
+func ClientIPKey(req *http.Request) string {
+	return ClientIP(req)
+}
+
+// HeaderKey uses the value of the header as the key
+func HeaderKey(header string) KeyExtractor {
+	return func(req *http.Request) string {
+		return req.Header.Get(header)
+	}
+}
+
+// TenantKey uses the tenant in the request context as the key
+func TenantKey(req *http.Request) string {
+	return GetTenant(req.Context())
+}
+
+// RouteKey uses the route template, or the path when the request was not routed by mux, as the key
+func RouteKey(req *http.Request) string {
+	return req.Method + " " + routeTemplate(req)
+}
+
+// routeTemplate returns the mux route template of the request, or the path when the request was not routed by mux
+func routeTemplate(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return req.URL.Path
+}
+
+// WithTenant returns a new context with the tenant of the caller
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// GetTenant gets the tenant of the caller from a context
+func GetTenant(ctx context.Context) string {
+	tenant, ok := ctx.Value(tenantContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return tenant
+}
+
+// RateLimiterConfig holds the configuration for the RateLimiter
+type RateLimiterConfig struct {
+	Limit RateLimit
+
+	// KeyFunc extracts the key the requests are limited by, defaults to ClientIPKey
+	KeyFunc KeyExtractor
+
+	// Store holds the token buckets, defaults to a MemoryRateLimitStore
+	Store RateLimitStore
+}
+
+// RateLimiter throttles the incoming requests per key
+type RateLimiter struct {
+	limit   RateLimit
+	keyFunc KeyExtractor
+	store   RateLimitStore
+}
+
+// NewRateLimiter creates a new RateLimiter from the config
+func NewRateLimiter(config RateLimiterConfig) (*RateLimiter, error) {
+	if config.Limit.Requests <= 0 || config.Limit.Period <= 0 {
+		return nil, fmt.Errorf("configuration must have a positive number of requests and period")
+	}
+
+	if config.Limit.interval() <= 0 {
+		return nil, fmt.Errorf("configuration must have a period of at least a nanosecond per request")
+	}
+
+	if config.KeyFunc == nil {
+		config.KeyFunc = ClientIPKey
+	}
+
+	if config.Store == nil {
+		store, err := NewMemoryRateLimitStore(defaultRateLimitStoreSize)
+		if err != nil {
+			return nil, err
+		}
+		config.Store = store
+	}
+
+	return &RateLimiter{
+		limit:   config.Limit,
+		keyFunc: config.KeyFunc,
+		store:   config.Store,
+	}, nil
+}
+
+// Middleware responds with 429 Too Many Requests when the key of the request is over its limit
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		key := l.keyFunc(req)
+		if key == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		result, err := l.store.Take(ctx, key, l.limit)
+		if err != nil {
+			// Fail open so an unavailable store does not take down the service
+			log.G(ctx).WithError(err).Error("Failed to check the rate limit")
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set(RateLimitLimitHeader, strconv.Itoa(l.limit.burst()))
+		w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+		w.Header().Set(RateLimitResetHeader, strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			log.G(ctx).WithFields(log.Fields{
+				"httpMethod":    req.Method,
+				"targetUri":     req.URL.String(),
+				"rateLimitKey":  hashRateLimitKey(key),
+				"retryAfter":    result.RetryAfter,
+				"correlationID": correlation.GetCorrelationID(ctx),
+				"activityID":    correlation.GetActivityID(ctx),
+			}).Warn("Incoming request throttled")
+			AddIncomingLogField(req, "throttled", true)
+
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			WriteProblem(w, req, NewProblem(http.StatusTooManyRequests, "rate limit exceeded"))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// hashRateLimitKey returns a short hash of the key for the logs, the keys can hold credentials, e.g. with HeaderKey
+func hashRateLimitKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ceilSeconds rounds the duration up to whole seconds for the headers
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,104 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimiterConfig{
+		Limit:   RateLimit{Requests: 2, Period: time.Minute},
+		KeyFunc: HeaderKey("X-Client-Id"),
+	})
+	require.NoError(t, err, "Should not get error creating the rate limiter")
+
+	handler := CorrelationMiddleware(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`OK`))
+	})))
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	send := func(clientID string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/customers", nil)
+		require.NoError(t, err, "Should not get error when creating a request")
+		AddStandardRequestHeaders(req)
+		req.Header.Set("X-Client-Id", clientID)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := send("client-a")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "1", first.Header().Get(RateLimitRemainingHeader))
+
+	assert.Equal(t, http.StatusOK, send("client-a").Code)
+
+	throttled := send("client-a")
+	assert.Equal(t, http.StatusTooManyRequests, throttled.Code, "Should throttle the third request")
+	assert.Equal(t, "30", throttled.Header().Get("Retry-After"), "Should wait for the next token")
+	assert.Equal(t, "0", throttled.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, problemContentType, throttled.Header().Get("Content-Type"))
+
+	entry := testHook.LastEntry()
+	assert.Equal(t, "Incoming request throttled", entry.Message)
+	assert.Equal(t, hashRateLimitKey("client-a"), getValueFromLog(*entry, "rateLimitKey", t), "Should not log the key")
+	assert.Equal(t, testCorrelationID, getValueFromLog(*entry, "correlationID", t), "Should log the correlation ID")
+
+	assert.Equal(t, http.StatusOK, send("client-b").Code, "Should limit each key separately")
+}
+
+func TestNewRateLimiterInterval(t *testing.T) {
+	_, err := NewRateLimiter(RateLimiterConfig{
+		Limit: RateLimit{Requests: 10, Period: time.Nanosecond},
+	})
+	assert.Error(t, err, "Should reject a limit refilling the tokens in less than a nanosecond")
+}
+
+func TestMemoryRateLimitStoreRefill(t *testing.T) {
+	store, err := NewMemoryRateLimitStore(10)
+	require.NoError(t, err)
+
+	limit := RateLimit{Requests: 10, Period: time.Millisecond * 100, Burst: 1}
+
+	result, err := store.Take(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = store.Take(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "Should empty the bucket")
+	assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= time.Millisecond*10)
+
+	time.Sleep(time.Millisecond * 15)
+
+	result, err = store.Take(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "Should refill the bucket")
+}
+
+func TestRouteKey(t *testing.T) {
+	var key string
+	router := mux.NewRouter()
+	router.HandleFunc("/customers/{id}", func(w http.ResponseWriter, req *http.Request) {
+		key = RouteKey(req)
+	})
+
+	req, err := http.NewRequest("GET", "/customers/123", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "GET /customers/{id}", key, "Should use the route template")
+}
@@ -0,0 +1,71 @@
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/samkreter/go-core/correlation"
+	"github.com/samkreter/go-core/log"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/trace"
+)
+
+// RecoveryMiddleware recovers panics from the handler, logs them with the stack trace
+// and returns a problem response if nothing was written yet
+func RecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w, rr := newResponseRecorder(w)
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// Let the server abort the response without recording a crash
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			ctx := req.Context()
+			stats.Record(ctx, ServerPanicCount.M(1))
+
+			fields := log.Fields{
+				"panic":         fmt.Sprint(recovered),
+				"stack":         string(debug.Stack()),
+				"httpMethod":    req.Method,
+				"targetUri":     req.URL.String(),
+				"correlationID": correlation.GetCorrelationID(ctx),
+				"activityID":    correlation.GetActivityID(ctx),
+			}
+
+			if span := trace.FromContext(ctx); span != nil {
+				spanContext := span.SpanContext()
+				fields["traceID"] = spanContext.TraceID.String()
+				fields["spanID"] = spanContext.SpanID.String()
+
+				span.AddAttributes(trace.BoolAttribute("error", true))
+				span.Annotate([]trace.Attribute{
+					trace.StringAttribute("panic", fmt.Sprint(recovered)),
+				}, "Recovered panic")
+				span.SetStatus(trace.Status{
+					Code:    trace.StatusCodeInternal,
+					Message: fmt.Sprint(recovered),
+				})
+			}
+
+			log.G(ctx).WithFields(fields).Error("Recovered panic in http handler")
+
+			// The response was already started, abort it so the client does not take it as complete
+			if rr.Status() != 0 {
+				panic(http.ErrAbortHandler)
+			}
+
+			WriteProblem(w, req, NewProblem(http.StatusInternalServerError, ""))
+		}()
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,80 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("test panic")
+	})
+
+	handler := SetUpHandler(testHandler, &HandlerConfig{
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+		TracingEnabled:     true,
+		RecoveryEnabled:    true,
+	})
+
+	require.NoError(t, view.Register(ServerPanicCountView))
+	defer view.Unregister(ServerPanicCountView)
+
+	req, err := http.NewRequest("GET", "/panic", nil)
+	require.NoError(t, err, "Should not get error when creating a request")
+	AddStandardRequestHeaders(req)
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code, "Should return internal server error")
+	assert.Equal(t, problemContentType, rr.Header().Get("Content-Type"), "Should return a problem response")
+
+	var problem Problem
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&problem))
+	assert.Equal(t, testCorrelationID, problem.CorrelationID, "Should return the correlation ID")
+
+	require.Equal(t, 3, len(testHook.Entries), "Should have correct number of logs")
+
+	crash := testHook.Entries[1]
+	assert.Equal(t, logrus.ErrorLevel, crash.Level)
+	assert.Equal(t, "test panic", getValueFromLog(crash, "panic", t), "Should log the panic value")
+	assert.Equal(t, testCorrelationID, getValueFromLog(crash, "correlationID", t), "Should log the correlation ID")
+	assert.NotEmpty(t, getValueFromLog(crash, "activityID", t), "Should log the activity ID")
+	assert.NotEmpty(t, getValueFromLog(crash, "traceID", t), "Should log the trace ID")
+	assert.Contains(t, getValueFromLog(crash, "stack", t), "recovery_test.go", "Should log the stack")
+
+	httpStatusCode, ok := testHook.Entries[2].Data["httpStatusCode"].(int)
+	require.True(t, ok, "Should have succesful cast.")
+	assert.Equal(t, http.StatusInternalServerError, httpStatusCode, "Should log the recovered status code")
+
+	rows, err := view.RetrieveData(ServerPanicCountView.Name)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rows), "Should record the panic")
+	assert.Equal(t, int64(1), rows[0].Data.(*view.CountData).Value, "Should count the panic")
+}
+
+func TestRecoveryMiddlewareAfterWrite(t *testing.T) {
+	handler := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`partial`))
+		panic("test panic")
+	}))
+
+	req, err := http.NewRequest("GET", "/panic", nil)
+	require.NoError(t, err, "Should not get error when creating a request")
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}, "Should abort the started response")
+}
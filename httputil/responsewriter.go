@@ -0,0 +1,339 @@
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// responseRecorder records the status, size and time to first byte of a response.
+// It is shared by the httputil middlewares, use newResponseRecorder to create it.
+type responseRecorder struct {
+	w     http.ResponseWriter
+	start time.Time
+
+	mu              sync.Mutex
+	statusCode      int
+	contentLength   int64
+	timeToFirstByte time.Duration
+	hijacked        bool
+
+	// body holds a copy of the response body up to maxBodyBytes once captureBody is called
+	body          *bytes.Buffer
+	maxBodyBytes  int64
+	bodyTruncated bool
+}
+
+// newResponseRecorder wraps the writer. The returned writer implements the same optional
+// http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom interfaces as w, so streaming,
+// websockets and sendfile keep working behind the middlewares.
+func newResponseRecorder(w http.ResponseWriter) (http.ResponseWriter, *responseRecorder) {
+	rr := &responseRecorder{
+		w:     w,
+		start: time.Now(),
+	}
+
+	return wrapResponseRecorder(rr), rr
+}
+
+func (r *responseRecorder) Header() http.Header { return r.w.Header() }
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.started(http.StatusOK)
+	n, err := r.w.Write(p)
+	r.addContentLength(int64(n))
+	r.capture(p[:n])
+	return n, err
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	// Informational responses are followed by the final response
+	if statusCode < http.StatusOK && statusCode != http.StatusSwitchingProtocols {
+		r.w.WriteHeader(statusCode)
+		return
+	}
+
+	r.started(statusCode)
+	r.w.WriteHeader(statusCode)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+// Status returns the status code of the response, 0 when nothing was written yet
+func (r *responseRecorder) Status() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.statusCode
+}
+
+// ContentLength returns the number of body bytes written
+func (r *responseRecorder) ContentLength() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.contentLength
+}
+
+// TimeToFirstByte returns the time until the response was started
+func (r *responseRecorder) TimeToFirstByte() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.timeToFirstByte
+}
+
+// Hijacked returns true when the handler took over the connection
+func (r *responseRecorder) Hijacked() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hijacked
+}
+
+// captureBody keeps a copy of the response body, the copy is dropped when the body is larger than maxBytes
+func (r *responseRecorder) captureBody(maxBytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.body = &bytes.Buffer{}
+	r.maxBodyBytes = maxBytes
+}
+
+// Body returns the captured body, false when it was not captured or larger than the limit
+func (r *responseRecorder) Body() ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.body == nil || r.bodyTruncated {
+		return nil, false
+	}
+
+	return r.body.Bytes(), true
+}
+
+func (r *responseRecorder) capturing() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.body != nil
+}
+
+func (r *responseRecorder) capture(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.body == nil || r.bodyTruncated {
+		return
+	}
+
+	if int64(r.body.Len()+len(p)) > r.maxBodyBytes {
+		r.bodyTruncated = true
+		r.body.Reset()
+		return
+	}
+
+	r.body.Write(p)
+}
+
+// started records the status of the response the first time it is written
+func (r *responseRecorder) started(statusCode int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.statusCode != 0 {
+		return
+	}
+
+	r.statusCode = statusCode
+	r.timeToFirstByte = time.Now().Sub(r.start)
+}
+
+func (r *responseRecorder) addContentLength(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contentLength += n
+}
+
+func (r *responseRecorder) flush() {
+	r.started(http.StatusOK)
+	r.w.(http.Flusher).Flush()
+}
+
+func (r *responseRecorder) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := r.w.(http.Hijacker).Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+
+	// The handler writes the response to the connection itself
+	r.started(http.StatusSwitchingProtocols)
+
+	r.mu.Lock()
+	r.hijacked = true
+	r.mu.Unlock()
+
+	return conn, rw, nil
+}
+
+func (r *responseRecorder) push(target string, opts *http.PushOptions) error {
+	return r.w.(http.Pusher).Push(target, opts)
+}
+
+func (r *responseRecorder) readFrom(src io.Reader) (int64, error) {
+	// The body does not go through Write when the underlying writer reads it
+	if r.capturing() {
+		return io.Copy(r, src)
+	}
+
+	r.started(http.StatusOK)
+	n, err := r.w.(io.ReaderFrom).ReadFrom(src)
+	r.addContentLength(n)
+	return n, err
+}
+
+type recorderFlusher struct{ rr *responseRecorder }
+
+func (f recorderFlusher) Flush() { f.rr.flush() }
+
+type recorderHijacker struct{ rr *responseRecorder }
+
+func (h recorderHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) { return h.rr.hijack() }
+
+type recorderPusher struct{ rr *responseRecorder }
+
+func (p recorderPusher) Push(target string, opts *http.PushOptions) error {
+	return p.rr.push(target, opts)
+}
+
+type recorderReaderFrom struct{ rr *responseRecorder }
+
+func (r recorderReaderFrom) ReadFrom(src io.Reader) (int64, error) { return r.rr.readFrom(src) }
+
+// wrapResponseRecorder returns a writer with only the optional interfaces of the underlying writer
+func wrapResponseRecorder(rr *responseRecorder) http.ResponseWriter {
+	const (
+		flusher = 1 << iota
+		hijacker
+		pusher
+		readerFrom
+	)
+
+	var kind int
+	if _, ok := rr.w.(http.Flusher); ok {
+		kind |= flusher
+	}
+	if _, ok := rr.w.(http.Hijacker); ok {
+		kind |= hijacker
+	}
+	if _, ok := rr.w.(http.Pusher); ok {
+		kind |= pusher
+	}
+	if _, ok := rr.w.(io.ReaderFrom); ok {
+		kind |= readerFrom
+	}
+
+	f := recorderFlusher{rr}
+	h := recorderHijacker{rr}
+	p := recorderPusher{rr}
+	r := recorderReaderFrom{rr}
+
+	switch kind {
+	case flusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+		}{rr, f}
+	case hijacker:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+		}{rr, h}
+	case flusher | hijacker:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+		}{rr, f, h}
+	case pusher:
+		return struct {
+			*responseRecorder
+			http.Pusher
+		}{rr, p}
+	case flusher | pusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Pusher
+		}{rr, f, p}
+	case hijacker | pusher:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+			http.Pusher
+		}{rr, h, p}
+	case flusher | hijacker | pusher:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rr, f, h, p}
+	case readerFrom:
+		return struct {
+			*responseRecorder
+			io.ReaderFrom
+		}{rr, r}
+	case flusher | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			io.ReaderFrom
+		}{rr, f, r}
+	case hijacker | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+			io.ReaderFrom
+		}{rr, h, r}
+	case flusher | hijacker | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{rr, f, h, r}
+	case pusher | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Pusher
+			io.ReaderFrom
+		}{rr, p, r}
+	case flusher | pusher | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{rr, f, p, r}
+	case hijacker | pusher | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{rr, h, p, r}
+	case flusher | hijacker | pusher | readerFrom:
+		return struct {
+			*responseRecorder
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{rr, f, h, p, r}
+	}
+
+	return rr
+}
@@ -0,0 +1,108 @@
+package httputil
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRecorderKeepsInterfaces(t *testing.T) {
+	var flusher, hijacker, pusher, readerFrom bool
+	record := func(w http.ResponseWriter) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+		_, pusher = w.(http.Pusher)
+		_, readerFrom = w.(io.ReaderFrom)
+	}
+
+	// The server response writer of HTTP/1 connections
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w, _ = newResponseRecorder(w)
+		record(w)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.True(t, flusher, "Should keep http.Flusher")
+	assert.True(t, hijacker, "Should keep http.Hijacker")
+	assert.False(t, pusher, "Should not add http.Pusher")
+	assert.True(t, readerFrom, "Should keep io.ReaderFrom")
+
+	w, _ := newResponseRecorder(httptest.NewRecorder())
+	record(w)
+
+	assert.True(t, flusher, "Should keep http.Flusher")
+	assert.False(t, hijacker, "Should not add http.Hijacker")
+	assert.False(t, readerFrom, "Should not add io.ReaderFrom")
+}
+
+func TestResponseRecorderStreaming(t *testing.T) {
+	var rr *responseRecorder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w, rr = newResponseRecorder(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, "data: event\n\n")
+			w.(http.Flusher).Flush()
+		}
+
+		// Uses sendfile for files
+		io.Copy(w, strings.NewReader("data: last\n\n"))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var events int
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data:") {
+			events++
+		}
+	}
+
+	assert.Equal(t, 4, events, "Should stream all the events")
+	assert.Equal(t, http.StatusOK, rr.Status())
+	assert.Equal(t, int64(3*len("data: event\n\n")+len("data: last\n\n")), rr.ContentLength(), "Should count the bytes written through ReadFrom")
+	assert.True(t, rr.TimeToFirstByte() > 0, "Should record the time to first byte")
+}
+
+func TestResponseRecorderHijack(t *testing.T) {
+	var rr *responseRecorder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w, rr = newResponseRecorder(w)
+
+		conn, bufrw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		bufrw.Flush()
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.True(t, rr.Hijacked(), "Should record the hijacked connection")
+	assert.Equal(t, http.StatusSwitchingProtocols, rr.Status())
+}
@@ -0,0 +1,402 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultSignatureMaxSkew = time.Minute * 5
+
+	defaultSignatureMaxBodyBytes = 1 << 20
+
+	signerContextKey = contextKey("signer")
+)
+
+var (
+	// SignatureHeader the request signature header
+	SignatureHeader = "X-Signature"
+
+	// SignatureKeyIDHeader the ID of the key used to sign the request
+	SignatureKeyIDHeader = "X-Signature-Key-Id"
+
+	// SignatureTimestampHeader the unix time the request was signed at
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+
+	// SignatureNonceHeader the unique value of the signed request
+	SignatureNonceHeader = "X-Signature-Nonce"
+
+	// SignatureHeadersHeader the list of signed headers
+	SignatureHeadersHeader = "X-Signature-Headers"
+
+	// ContentDigestHeader the digest of the request body
+	ContentDigestHeader = "X-Content-Digest"
+)
+
+type contextKey string
+
+// SigningKey shared key used to sign and verify requests
+type SigningKey struct {
+	// ID identifies the key, allows for multiple active keys during rotation
+	ID string
+
+	// Signer is the identity of the service signing with the key, defaults to the ID
+	Signer string
+
+	Secret []byte
+}
+
+func (k SigningKey) signer() string {
+	if k.Signer != "" {
+		return k.Signer
+	}
+
+	return k.ID
+}
+
+// SigningTransport implements http.RoundTripper.
+// When set as Transport of http.Client, it signs the requests with the shared key.
+type SigningTransport struct {
+	Transport http.RoundTripper
+	Key       SigningKey
+
+	// SignedHeaders are the request headers included in the signature
+	SignedHeaders []string
+}
+
+// RoundTrip implements http.RoundTripper and signs the client requests
+func (t *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body is only replaced on the clone, a RoundTripper must not modify the request
+	signedReq := req.Clone(req.Context())
+	body, err := readBody(signedReq)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]string, 0, len(t.SignedHeaders))
+	for _, header := range t.SignedHeaders {
+		headers = append(headers, strings.ToLower(header))
+	}
+
+	signedReq.Header.Set(SignatureKeyIDHeader, t.Key.ID)
+	signedReq.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	signedReq.Header.Set(SignatureNonceHeader, nonce)
+	signedReq.Header.Set(SignatureHeadersHeader, strings.Join(headers, ";"))
+	signedReq.Header.Set(ContentDigestHeader, contentDigest(body))
+	signedReq.Header.Set(SignatureHeader, sign(t.Key.Secret, signedReq, headers))
+
+	return t.transport().RoundTrip(signedReq)
+}
+
+func (t *SigningTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+
+	return http.DefaultTransport
+}
+
+// SignatureVerifierConfig configuration for the SignatureVerifier
+type SignatureVerifierConfig struct {
+	Keys []SigningKey
+
+	// RequiredHeaders are the request headers which must be included in the signature
+	RequiredHeaders []string
+
+	// MaxSkew is the maximum age of a signature, defaults to 5 minutes
+	MaxSkew time.Duration
+
+	// MaxBodyBytes limits the request bodies read to verify the digest, defaults to 1MB
+	MaxBodyBytes int64
+}
+
+// SignatureVerifier verifies the requests signed by the SigningTransport
+type SignatureVerifier struct {
+	maxSkew         time.Duration
+	maxBodyBytes    int64
+	requiredHeaders []string
+
+	sync.RWMutex
+	keys map[string]SigningKey
+
+	nonces *nonceCache
+}
+
+// NewSignatureVerifier creates a new signature verifier
+func NewSignatureVerifier(config SignatureVerifierConfig) (*SignatureVerifier, error) {
+	if len(config.Keys) == 0 {
+		return nil, fmt.Errorf("configuration must have at least one key")
+	}
+
+	if config.MaxSkew == 0 {
+		config.MaxSkew = defaultSignatureMaxSkew
+	}
+
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultSignatureMaxBodyBytes
+	}
+
+	v := &SignatureVerifier{
+		maxSkew:      config.MaxSkew,
+		maxBodyBytes: config.MaxBodyBytes,
+		nonces:       &nonceCache{nonces: make(map[string]time.Time)},
+	}
+
+	for _, header := range config.RequiredHeaders {
+		v.requiredHeaders = append(v.requiredHeaders, strings.ToLower(header))
+	}
+
+	v.SetKeys(config.Keys)
+
+	return v, nil
+}
+
+// SetKeys replaces the keys accepted by the verifier
+func (v *SignatureVerifier) SetKeys(keys []SigningKey) {
+	keysByID := make(map[string]SigningKey, len(keys))
+	for _, key := range keys {
+		keysByID[key.ID] = key
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	v.keys = keysByID
+}
+
+// Middleware rejects the requests without a valid signature and adds the signer to the context
+func (v *SignatureVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		if req.ContentLength > v.maxBodyBytes {
+			WriteProblem(w, req, NewProblem(http.StatusRequestEntityTooLarge, "request body is too large to verify the signature"))
+			return
+		}
+
+		// The body is read before the signature is checked, limit it on a copy of the request
+		req = req.WithContext(ctx)
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = http.MaxBytesReader(w, req.Body, v.maxBodyBytes)
+		}
+
+		signer, err := v.verify(req)
+		if err != nil {
+			log.G(ctx).WithFields(log.Fields{
+				"keyID": req.Header.Get(SignatureKeyIDHeader),
+			}).WithError(err).Warn("Request signature verification failed")
+
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				WriteProblem(w, req, NewProblem(http.StatusRequestEntityTooLarge, "request body is too large to verify the signature"))
+				return
+			}
+
+			WriteProblem(w, req, NewProblem(http.StatusUnauthorized, "invalid request signature"))
+			return
+		}
+
+		ctx = WithSigner(ctx, signer)
+		ctx = log.WithLogger(ctx, log.G(ctx).WithField("signer", signer))
+		AddIncomingLogField(req, "signer", signer)
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// verify checks the request signature and returns the signer
+func (v *SignatureVerifier) verify(req *http.Request) (string, error) {
+	keyID := req.Header.Get(SignatureKeyIDHeader)
+
+	v.RLock()
+	key, ok := v.keys[keyID]
+	v.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown key ID %q", keyID)
+	}
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(SignatureTimestampHeader), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature timestamp")
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if skew := time.Since(signedAt); skew > v.maxSkew || skew < -v.maxSkew {
+		return "", fmt.Errorf("signature timestamp outside of the allowed skew")
+	}
+
+	headers := strings.Split(req.Header.Get(SignatureHeadersHeader), ";")
+	for _, required := range v.requiredHeaders {
+		if !containsString(headers, required) {
+			return "", fmt.Errorf("required header %q is not signed", required)
+		}
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return "", err
+	}
+
+	if !hmac.Equal([]byte(req.Header.Get(ContentDigestHeader)), []byte(contentDigest(body))) {
+		return "", fmt.Errorf("body digest mismatch")
+	}
+
+	expected := sign(key.Secret, req, headers)
+	if !hmac.Equal([]byte(req.Header.Get(SignatureHeader)), []byte(expected)) {
+		return "", fmt.Errorf("signature mismatch")
+	}
+
+	// Only remember the nonce once the signature is valid so forged requests can't fill the cache
+	if !v.nonces.add(keyID+":"+req.Header.Get(SignatureNonceHeader), signedAt.Add(v.maxSkew)) {
+		return "", fmt.Errorf("replayed request")
+	}
+
+	return key.signer(), nil
+}
+
+// WithSigner returns a new context with the verified signer
+func WithSigner(ctx context.Context, signer string) context.Context {
+	return context.WithValue(ctx, signerContextKey, signer)
+}
+
+// GetSigner gets the verified signer from a context
+func GetSigner(ctx context.Context) string {
+	signer, ok := ctx.Value(signerContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return signer
+}
+
+// nonceCache remembers the nonces of the verified requests until their signatures expire
+type nonceCache struct {
+	sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// add returns false if the nonce was already seen
+func (c *nonceCache) add(nonce string, expiry time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for n, exp := range c.nonces {
+			if now.After(exp) {
+				delete(c.nonces, n)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if _, ok := c.nonces[nonce]; ok {
+		return false
+	}
+
+	c.nonces[nonce] = expiry
+	return true
+}
+
+// sign returns the signature over the canonical form of the request.
+// The host is signed so a request can't be replayed to another service sharing the key.
+func sign(secret []byte, req *http.Request, headers []string) string {
+	var canonical strings.Builder
+
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(requestHost(req) + "\n")
+	canonical.WriteString(req.URL.RequestURI() + "\n")
+	canonical.WriteString(req.Header.Get(SignatureKeyIDHeader) + "\n")
+	canonical.WriteString(req.Header.Get(SignatureTimestampHeader) + "\n")
+	canonical.WriteString(req.Header.Get(SignatureNonceHeader) + "\n")
+
+	for _, header := range headers {
+		if header == "" {
+			continue
+		}
+		canonical.WriteString(header + ":" + strings.TrimSpace(req.Header.Get(header)) + "\n")
+	}
+
+	canonical.WriteString(req.Header.Get(ContentDigestHeader))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// requestHost returns the lowercased host the request is sent to
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return strings.ToLower(req.Host)
+	}
+
+	return strings.ToLower(req.URL.Host)
+}
+
+// readBody reads the request body and replaces it with the read bytes, or reads it from GetBody
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+func generateNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
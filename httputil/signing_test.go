@@ -0,0 +1,200 @@
+package httputil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSigningKey = SigningKey{
+		ID:     "key-1",
+		Signer: "frontend",
+		Secret: []byte("test-secret"),
+	}
+)
+
+func newTestSignatureVerifier(t *testing.T) *SignatureVerifier {
+	verifier, err := NewSignatureVerifier(SignatureVerifierConfig{
+		Keys:            []SigningKey{testSigningKey},
+		RequiredHeaders: []string{"Content-Type"},
+	})
+	require.NoError(t, err, "Should not get error creating the verifier")
+
+	return verifier
+}
+
+func TestSignedRequest(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "frontend", GetSigner(req.Context()), "Should add the signer to the context")
+
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		w.Write(b)
+	})
+
+	handler := SetUpHandler(newTestSignatureVerifier(t).Middleware(testHandler), &HandlerConfig{
+		CorrelationEnabled: true,
+		LoggingEnabled:     true,
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	c := &http.Client{
+		Transport: &SigningTransport{
+			Key:           testSigningKey,
+			SignedHeaders: []string{"Content-Type"},
+		},
+	}
+
+	resp, err := c.Post(server.URL+"/orders?id=1", "application/json", bytes.NewBufferString(`{"id": 1}`))
+	require.NoError(t, err, "Should not get error for server response")
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode, "Should accept the signed request")
+	assert.Equal(t, `{"id": 1}`, string(b), "Should pass the body to the handler")
+
+	signer := getValueFromLog(*testHook.LastEntry(), "signer", t)
+	assert.Equal(t, "frontend", signer, "Should log the signer")
+}
+
+func TestSignatureVerificationFailures(t *testing.T) {
+	verifier := newTestSignatureVerifier(t)
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`OK`))
+	}))
+
+	// signedRequest captures the headers set by the SigningTransport
+	signedRequest := func(key SigningKey) *http.Request {
+		var signed *http.Request
+		transport := &SigningTransport{
+			Key:           key,
+			SignedHeaders: []string{"Content-Type"},
+			Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				signed = req
+
+				// Incoming server requests don't have GetBody set
+				signed.GetBody = nil
+				return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+			}),
+		}
+
+		req, err := http.NewRequest("POST", "http://example.com/orders", bytes.NewBufferString("body"))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+
+		_, err = transport.RoundTrip(req)
+		require.NoError(t, err)
+
+		return signed
+	}
+
+	serve := func(req *http.Request) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	req := signedRequest(testSigningKey)
+	assert.Equal(t, 200, serve(req), "Should accept the signed request")
+	req.Body = ioutil.NopCloser(bytes.NewBufferString("body"))
+	assert.Equal(t, 401, serve(req), "Should reject a replayed request")
+
+	req = signedRequest(testSigningKey)
+	req.Body = ioutil.NopCloser(bytes.NewBufferString("tampered"))
+	assert.Equal(t, 401, serve(req), "Should reject a tampered body")
+
+	req = signedRequest(testSigningKey)
+	req.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, 401, serve(req), "Should reject a tampered signed header")
+
+	req = signedRequest(testSigningKey)
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	assert.Equal(t, 401, serve(req), "Should reject a stale timestamp")
+
+	req = signedRequest(testSigningKey)
+	req.Host = "payments.example.com"
+	assert.Equal(t, 401, serve(req), "Should reject a request replayed to another host")
+
+	rotatedKey := SigningKey{ID: "key-2", Signer: "frontend", Secret: []byte("rotated-secret")}
+	req = signedRequest(rotatedKey)
+	assert.Equal(t, 401, serve(req), "Should reject an unknown key")
+
+	verifier.SetKeys([]SigningKey{testSigningKey, rotatedKey})
+	req = signedRequest(rotatedKey)
+	assert.Equal(t, 200, serve(req), "Should accept the rotated key")
+}
+
+func TestSigningTransportKeepsRequest(t *testing.T) {
+	transport := &SigningTransport{
+		Key: testSigningKey,
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			b, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "body", string(b), "Should send the body")
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		}),
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com/orders", bytes.NewBufferString("body"))
+	require.NoError(t, err)
+	req.GetBody = nil
+	body := req.Body
+
+	_, err = transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, body, req.Body, "Should not replace the body of the caller's request")
+	assert.Empty(t, req.Header.Get(SignatureHeader), "Should not sign the caller's request")
+}
+
+func TestSignatureVerificationBodyLimit(t *testing.T) {
+	verifier, err := NewSignatureVerifier(SignatureVerifierConfig{
+		Keys:         []SigningKey{testSigningKey},
+		MaxBodyBytes: 8,
+	})
+	require.NoError(t, err)
+
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`OK`))
+	}))
+
+	req := httptest.NewRequest("POST", "/orders", bytes.NewBufferString("too large body"))
+	req.Header.Set(SignatureKeyIDHeader, testSigningKey.ID)
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "Should reject a large Content-Length before reading")
+	assert.Equal(t, problemContentType, rr.Header().Get("Content-Type"))
+
+	req = httptest.NewRequest("POST", "/orders", bytes.NewBufferString("too large body"))
+	req.ContentLength = -1
+	req.Header.Set(SignatureKeyIDHeader, testSigningKey.ID)
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "Should stop reading a chunked body at the limit")
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,340 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultTLSReloadInterval = 30 * time.Second
+
+	peerIdentityContextKey = contextKey("peerIdentity")
+
+	// PeerIdentityMiddlewareName is the name of the PeerIdentityMiddleware in a Chain
+	PeerIdentityMiddlewareName = "peeridentity"
+)
+
+// secureCipherSuites are the TLS 1.2 cipher suites with forward secrecy and authenticated encryption,
+// the TLS 1.3 cipher suites are not configurable
+var secureCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// TLSConfig holds the PEM files of the TLSReloader
+type TLSConfig struct {
+	// CertFile and KeyFile are the certificate of the server, or the client certificate for mTLS
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the CA bundle verifying the client certificates on the server,
+	// or the server certificates on the client. The client uses the system roots when not set.
+	CAFile string
+
+	// ClientAuth is the server policy for the client certificates, client certificates are not requested by default.
+	// Use tls.RequireAndVerifyClientCert for mTLS.
+	ClientAuth tls.ClientAuthType
+
+	// ServerName overrides the name the client verifies the server certificate with
+	ServerName string
+
+	// ReloadInterval is how often the files are checked for changes, defaults to 30s
+	ReloadInterval time.Duration
+}
+
+// TLSReloader builds tls.Configs from PEM files and reloads the files when they change on disk,
+// e.g. when a Kubernetes secret is rotated
+type TLSReloader struct {
+	config TLSConfig
+
+	mu     sync.RWMutex
+	files  map[string][]byte
+	cert   *tls.Certificate
+	caPool *x509.CertPool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// PeerIdentity is the identity of the verified client certificate of a request
+type PeerIdentity struct {
+	Subject string
+	SANs    []string
+}
+
+// NewTLSReloader loads the files of the config and starts reloading them
+func NewTLSReloader(config TLSConfig) (*TLSReloader, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("configuration must have both a CertFile and a KeyFile or neither")
+	}
+
+	if config.ClientAuth >= tls.VerifyClientCertIfGiven && config.CAFile == "" {
+		return nil, errors.New("configuration must have a CAFile to verify the client certificates")
+	}
+
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = defaultTLSReloadInterval
+	}
+
+	r := &TLSReloader{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+// Close stops reloading the files
+func (r *TLSReloader) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// ServerTLSConfig returns a server tls.Config with secure defaults using the current certificate and CA bundle
+func (r *TLSReloader) ServerTLSConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     secureCipherSuites,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		ClientAuth:       r.config.ClientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+
+	if r.config.CAFile != "" {
+		// The client CAs are read per connection so the reloaded bundle is used for the new connections
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig := config.Clone()
+			clientConfig.GetConfigForClient = nil
+			clientConfig.ClientCAs = r.pool()
+			return clientConfig, nil
+		}
+	}
+
+	return config
+}
+
+// ClientTLSConfig returns a client tls.Config with secure defaults using the current client certificate and CA bundle.
+// The server certificates are verified against the CA bundle of the time of the call, use DialTLSContext so the
+// new connections verify them against the reloaded bundle.
+func (r *TLSReloader) ClientTLSConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: r.config.ServerName,
+		RootCAs:    r.pool(),
+	}
+
+	if r.config.CertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		}
+	}
+
+	return config
+}
+
+// DialTLSContext returns a function for the DialTLSContext of a http.Transport connecting with dial, defaults to a net.Dialer.
+// Each connection uses the current ClientTLSConfig, see dialTLSContext for the name the server certificate is verified against.
+func (r *TLSReloader) DialTLSContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return dialTLSContext(r.ClientTLSConfig, dial)
+}
+
+// dialTLSContext returns a function for the DialTLSContext of a http.Transport connecting with dial, defaults to a net.Dialer.
+// The server certificate is verified against the configured ServerName, the service name of a load balanced request
+// or the dialed host.
+func dialTLSContext(tlsConfig func() *tls.Config, dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		config := tlsConfig()
+		if config.ServerName == "" {
+			config.ServerName = getTLSServerName(ctx)
+		}
+		if config.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			config.ServerName = host
+		}
+
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+}
+
+func (r *TLSReloader) certificate() (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cert == nil {
+		return nil, errors.New("no certificate configured")
+	}
+
+	return r.cert, nil
+}
+
+func (r *TLSReloader) pool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.caPool
+}
+
+func (r *TLSReloader) run() {
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logger := log.G(context.TODO()).WithFields(log.Fields{
+				"certFile": r.config.CertFile,
+				"caFile":   r.config.CAFile,
+			})
+
+			// Keep the current files when the new ones are invalid, e.g. while they are being written
+			changed, err := r.reload()
+			if err != nil {
+				logger.WithError(err).Error("Failed to reload the TLS files")
+			} else if changed {
+				logger.Info("Reloaded the TLS files")
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reload reads the files and replaces the certificate and CA bundle when they changed
+func (r *TLSReloader) reload() (bool, error) {
+	files := make(map[string][]byte)
+	for _, name := range []string{r.config.CertFile, r.config.KeyFile, r.config.CAFile} {
+		if name == "" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return false, err
+		}
+		files[name] = b
+	}
+
+	r.mu.RLock()
+	changed := len(files) != len(r.files)
+	for name, b := range files {
+		if !bytes.Equal(b, r.files[name]) {
+			changed = true
+		}
+	}
+	r.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if r.config.CertFile != "" {
+		c, err := tls.X509KeyPair(files[r.config.CertFile], files[r.config.KeyFile])
+		if err != nil {
+			return false, err
+		}
+		cert = &c
+	}
+
+	var caPool *x509.CertPool
+	if r.config.CAFile != "" {
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(files[r.config.CAFile]) {
+			return false, fmt.Errorf("no certificates found in %s", r.config.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.files = files
+	r.cert = cert
+	r.caPool = caPool
+	return true, nil
+}
+
+// PeerIdentityMiddleware adds the identity of the verified client certificate to the context and the
+// incoming request log. It only has an effect for servers verifying the client certificates.
+func PeerIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		leaf := req.TLS.VerifiedChains[0][0]
+		identity := PeerIdentity{
+			Subject: leaf.Subject.String(),
+		}
+
+		identity.SANs = append(identity.SANs, leaf.DNSNames...)
+		identity.SANs = append(identity.SANs, leaf.EmailAddresses...)
+		for _, ip := range leaf.IPAddresses {
+			identity.SANs = append(identity.SANs, ip.String())
+		}
+		for _, uri := range leaf.URIs {
+			identity.SANs = append(identity.SANs, uri.String())
+		}
+
+		ctx := WithPeerIdentity(req.Context(), identity)
+		ctx = log.WithLogger(ctx, log.G(ctx).WithField("peerSubject", identity.Subject))
+
+		AddIncomingLogField(req, "peerSubject", identity.Subject)
+		AddIncomingLogField(req, "peerSANs", identity.SANs)
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// WithPeerIdentity returns a new context with the identity of the client certificate
+func WithPeerIdentity(ctx context.Context, identity PeerIdentity) context.Context {
+	return context.WithValue(ctx, peerIdentityContextKey, identity)
+}
+
+// GetPeerIdentity gets the identity of the client certificate from a context
+func GetPeerIdentity(ctx context.Context) (PeerIdentity, bool) {
+	identity, ok := ctx.Value(peerIdentityContextKey).(PeerIdentity)
+	return identity, ok
+}
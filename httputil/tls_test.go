@@ -0,0 +1,211 @@
+package httputil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate for the name and 127.0.0.1 signed by the parent, or a self-signed CA without a parent
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	return newTestCertWithIPs(t, name, parent, net.ParseIP("127.0.0.1"))
+}
+
+// newTestCertWithIPs creates a certificate for the name and IPs signed by the parent, or a self-signed CA without a parent
+func newTestCertWithIPs(t *testing.T, name string, parent *testCert, ips ...net.IP) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  ips,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestCert(t *testing.T, dir, name string, cert *testCert) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(certFile, cert.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, cert.keyPEM, 0600))
+	return certFile, keyFile
+}
+
+func TestTLSReloaderMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := writeTestCert(t, dir, "ca", ca)
+	serverCertFile, serverKeyFile := writeTestCert(t, dir, "server", newTestCert(t, "server", ca))
+	clientCertFile, clientKeyFile := writeTestCert(t, dir, "client", newTestCert(t, "client", ca))
+
+	serverTLS, err := NewTLSReloader(TLSConfig{
+		CertFile:   serverCertFile,
+		KeyFile:    serverKeyFile,
+		CAFile:     caFile,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err, "Should not get error creating the server reloader")
+	defer serverTLS.Close()
+
+	clientTLS, err := NewTLSReloader(TLSConfig{
+		CertFile:   clientCertFile,
+		KeyFile:    clientKeyFile,
+		CAFile:     caFile,
+		ServerName: "server",
+	})
+	require.NoError(t, err, "Should not get error creating the client reloader")
+	defer clientTLS.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS.ServerTLSConfig())
+	require.NoError(t, err)
+
+	var identity PeerIdentity
+	go http.Serve(listener, PeerIdentityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity, _ = GetPeerIdentity(req.Context())
+	})))
+	defer listener.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialTLSContext:    clientTLS.DialTLSContext(nil),
+		DisableKeepAlives: true,
+	}}
+	url := "https://" + listener.Addr().String()
+
+	resp, err := client.Get(url)
+	require.NoError(t, err, "Should connect with mTLS")
+	resp.Body.Close()
+	assert.Equal(t, "CN=client", identity.Subject, "Should expose the client certificate subject")
+	assert.Contains(t, identity.SANs, "client")
+
+	// Rotate the CA and the certificates
+	rotatedCA := newTestCert(t, "rotated-ca", nil)
+	writeTestCert(t, dir, "server", newTestCert(t, "server", rotatedCA))
+	require.NoError(t, ioutil.WriteFile(caFile, append(ca.certPEM, rotatedCA.certPEM...), 0600))
+
+	resp, err = client.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "ca", resp.TLS.PeerCertificates[0].Issuer.CommonName, "Should serve the previous certificate until the reload")
+
+	for _, r := range []*TLSReloader{serverTLS, clientTLS} {
+		changed, err := r.reload()
+		require.NoError(t, err)
+		assert.True(t, changed, "Should reload the changed files")
+	}
+
+	resp, err = client.Get(url)
+	require.NoError(t, err, "Should trust the rotated server certificate after the reload")
+	resp.Body.Close()
+	assert.Equal(t, "rotated-ca", resp.TLS.PeerCertificates[0].Issuer.CommonName)
+}
+
+func TestTLSReloaderVerifiesIPHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := writeTestCert(t, dir, "ca", ca)
+
+	// The certificate is signed by the CA but not issued for the IP of the server
+	serverCertFile, serverKeyFile := writeTestCert(t, dir, "server", newTestCertWithIPs(t, "other.example", ca))
+
+	serverTLS, err := NewTLSReloader(TLSConfig{CertFile: serverCertFile, KeyFile: serverKeyFile})
+	require.NoError(t, err)
+	defer serverTLS.Close()
+
+	clientTLS, err := NewTLSReloader(TLSConfig{CAFile: caFile})
+	require.NoError(t, err)
+	defer clientTLS.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS.ServerTLSConfig())
+	require.NoError(t, err)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer listener.Close()
+
+	url := "https://" + listener.Addr().String()
+
+	for name, transport := range map[string]*http.Transport{
+		"TLSClientConfig": {TLSClientConfig: clientTLS.ClientTLSConfig()},
+		"DialTLSContext":  {DialTLSContext: clientTLS.DialTLSContext(nil)},
+	} {
+		_, err := (&http.Client{Transport: transport}).Get(url)
+		assert.Error(t, err, "%s should reject a certificate without the IP of the server", name)
+	}
+}
+
+func TestTLSReloaderKeepsValidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "server", newTestCert(t, "server", nil))
+
+	r, err := NewTLSReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("partial"), 0600))
+	_, err = r.reload()
+	assert.Error(t, err, "Should fail to load an invalid certificate")
+
+	cert, err := r.certificate()
+	require.NoError(t, err)
+	assert.NotNil(t, cert, "Should keep the previous certificate")
+
+	_, err = NewTLSReloader(TLSConfig{CertFile: certFile})
+	assert.Error(t, err, "Should require the key file")
+}
@@ -0,0 +1,251 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+// ContextFieldsFunc returns the fields of a context to add to the log records
+type ContextFieldsFunc func(ctx context.Context) Fields
+
+var (
+	contextFieldsMu    sync.RWMutex
+	contextFieldsFuncs []ContextFieldsFunc
+)
+
+// RegisterContextFields adds a function to the fields read from the context of the slog records.
+// The correlation package registers the correlation ID, activity ID and metadata headers.
+func RegisterContextFields(fn ContextFieldsFunc) {
+	contextFieldsMu.Lock()
+	defer contextFieldsMu.Unlock()
+
+	contextFieldsFuncs = append(contextFieldsFuncs, fn)
+}
+
+// ContextFields returns the registered fields and the trace and span IDs of the context
+func ContextFields(ctx context.Context) Fields {
+	fields := Fields{}
+
+	if span := trace.FromContext(ctx); span != nil {
+		spanContext := span.SpanContext()
+		fields["traceID"] = spanContext.TraceID.String()
+		fields["spanID"] = spanContext.SpanID.String()
+	}
+
+	contextFieldsMu.RLock()
+	defer contextFieldsMu.RUnlock()
+
+	for _, fn := range contextFieldsFuncs {
+		for k, v := range fn(ctx) {
+			fields[k] = v
+		}
+	}
+
+	return fields
+}
+
+// ContextHandler is a slog.Handler adding the context fields to every record before passing it to the next handler,
+// e.g. slog.New(log.NewContextHandler(log.NewLogrusHandler(logrus.StandardLogger()))).InfoContext(ctx, "message").
+// Keep it the outermost handler, the context fields are added at the root of the record outside the groups.
+type ContextHandler struct {
+	next slog.Handler
+
+	// groups are the open groups with the attributes added after them, they are passed to next with the record
+	groups []contextGroup
+}
+
+type contextGroup struct {
+	name  string
+	attrs []slog.Attr
+}
+
+// NewContextHandler creates a new ContextHandler
+func NewContextHandler(next slog.Handler) *ContextHandler {
+	return &ContextHandler{next: next}
+}
+
+// Enabled reports whether the next handler handles the level
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle adds the non empty context fields to the root of the record
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	out := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+
+	fields := ContextFields(ctx)
+	for _, k := range sortedKeys(fields) {
+		if v, ok := fields[k].(string); ok && v == "" {
+			continue
+		}
+		out.AddAttrs(slog.Any(k, fields[k]))
+	}
+
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		group := h.groups[i]
+		attrs = append(append([]slog.Attr{}, group.attrs...), attrs...)
+		attrs = []slog.Attr{{Key: group.name, Value: slog.GroupValue(attrs...)}}
+	}
+	out.AddAttrs(attrs...)
+
+	return h.next.Handle(ctx, out)
+}
+
+// WithAttrs returns a ContextHandler with the attributes, they are added to the next handler when no group is open
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	if len(h.groups) == 0 {
+		return &ContextHandler{next: h.next.WithAttrs(attrs)}
+	}
+
+	groups := append([]contextGroup{}, h.groups...)
+	last := &groups[len(groups)-1]
+	last.attrs = append(append([]slog.Attr{}, last.attrs...), attrs...)
+
+	return &ContextHandler{next: h.next, groups: groups}
+}
+
+// WithGroup returns a ContextHandler with the group open, the context fields stay outside of it
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	groups := append(append([]contextGroup{}, h.groups...), contextGroup{name: name})
+	return &ContextHandler{next: h.next, groups: groups}
+}
+
+// LogrusHandler is a slog.Handler writing the records to a logrus logger, so the logrus hooks
+// such as the LoggingHub hook receive them. The attributes of groups are prefixed with the group names.
+type LogrusHandler struct {
+	logger *logrus.Logger
+	fields Fields
+	groups []string
+}
+
+// NewLogrusHandler creates a new LogrusHandler using the level of the logger
+func NewLogrusHandler(logger *logrus.Logger) *LogrusHandler {
+	return &LogrusHandler{
+		logger: logger,
+		fields: Fields{},
+	}
+}
+
+// Enabled reports whether the logger logs the level
+func (h *LogrusHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return logrusLevel(level) <= Level(h.logger.GetLevel())
+}
+
+// Handle writes the record to the logger. Records enabled by a handler wrapping it, e.g. for
+// the debug escalation of NewSlogLogger, are written at their level to the output and hooks of the logger.
+func (h *LogrusHandler) Handle(ctx context.Context, record slog.Record) error {
+	fields := make(Fields, len(h.fields)+record.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+
+	prefix := h.prefix()
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(fields, prefix, attr)
+		return true
+	})
+
+	level := logrusLevel(record.Level)
+
+	logger := h.logger
+	if !logger.IsLevelEnabled(logrus.Level(level)) {
+		logger = leveledLogrusLogger(logger, level)
+	}
+
+	logrus.NewEntry(logger).WithContext(ctx).WithTime(record.Time).WithFields(fields).Log(logrus.Level(level), record.Message)
+
+	return nil
+}
+
+// WithAttrs returns a LogrusHandler with the attributes added to every record
+func (h *LogrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+
+	prefix := h.prefix()
+	for _, attr := range attrs {
+		addAttr(fields, prefix, attr)
+	}
+
+	return &LogrusHandler{logger: h.logger, fields: fields, groups: h.groups}
+}
+
+// WithGroup returns a LogrusHandler prefixing the attributes of the next records with the group name
+func (h *LogrusHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	groups := append(append([]string{}, h.groups...), name)
+	return &LogrusHandler{logger: h.logger, fields: h.fields, groups: groups}
+}
+
+func (h *LogrusHandler) prefix() string {
+	if len(h.groups) == 0 {
+		return ""
+	}
+
+	return strings.Join(h.groups, ".") + "."
+}
+
+func addAttr(fields Fields, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+
+		for _, groupAttr := range value.Group() {
+			addAttr(fields, groupPrefix, groupAttr)
+		}
+		return
+	}
+
+	if attr.Key == "" {
+		return
+	}
+
+	fields[prefix+attr.Key] = value.Any()
+}
+
+// logrusLevel returns the level of a slog level, the reverse of SlogLevel.
+// The levels above error are mapped to fatal so the handler never panics.
+func logrusLevel(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError+4:
+		return FatalLevel
+	case level >= slog.LevelError:
+		return ErrorLevel
+	case level >= slog.LevelWarn:
+		return WarnLevel
+	case level >= slog.LevelInfo:
+		return InfoLevel
+	case level >= slog.LevelDebug:
+		return DebugLevel
+	default:
+		return TraceLevel
+	}
+}
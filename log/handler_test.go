@@ -0,0 +1,57 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+)
+
+func TestLogrusHandler(t *testing.T) {
+	logger, hook := logrustest.NewNullLogger()
+	logger.SetLevel(logrus.InfoLevel)
+
+	slogger := slog.New(NewContextHandler(NewLogrusHandler(logger))).With("service", "test")
+
+	slogger.Debug("dropped")
+	assert.Empty(t, hook.AllEntries(), "Should use the level of the logger")
+
+	ctx, span := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+
+	slogger.WithGroup("request").With("method", "GET").ErrorContext(ctx, "Failed", "status", 500, slog.Group("user", "id", "1"))
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, logrus.ErrorLevel, entry.Level)
+	assert.Equal(t, "Failed", entry.Message)
+	assert.Equal(t, "test", entry.Data["service"])
+	assert.Equal(t, int64(500), entry.Data["request.status"], "Should prefix the attributes with the groups")
+	assert.Equal(t, "GET", entry.Data["request.method"], "Should keep the attributes added in the group")
+	assert.Equal(t, "1", entry.Data["request.user.id"])
+	assert.Equal(t, span.SpanContext().TraceID.String(), entry.Data["traceID"], "Should add the trace ID outside the groups")
+	assert.Equal(t, span.SpanContext().SpanID.String(), entry.Data["spanID"], "Should add the span ID outside the groups")
+	assert.NotContains(t, entry.Data, "request.traceID")
+}
+
+func TestLogrusHandlerEscalation(t *testing.T) {
+	logger, hook := logrustest.NewNullLogger()
+	logger.SetLevel(logrus.InfoLevel)
+
+	slogger := NewSlogLogger(slog.New(NewLogrusHandler(logger)))
+	slogger.Debug("dropped")
+	assert.Empty(t, hook.AllEntries(), "Should use the level of the logrus logger")
+
+	slogger.WithLevel(DebugLevel).Debug("kept")
+
+	entry := hook.LastEntry()
+	if assert.NotNil(t, entry, "Should write the records of an escalated logger") {
+		assert.Equal(t, logrus.DebugLevel, entry.Level)
+	}
+	assert.Equal(t, logrus.InfoLevel, logger.GetLevel(), "Should not change the level of the logrus logger")
+}
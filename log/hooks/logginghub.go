@@ -47,11 +47,16 @@ type LoggingHubEntry struct {
 // LoggingHubHook logrus hook for the logging agent
 type LoggingHubHook struct {
 	config       Config
+	levelsMu     sync.RWMutex
 	levels       []logrus.Level
 	pendingLogs  *pendingLogs
 	channel      chan *LoggingHubEntry
 	ignoreFields map[string]struct{}
 	filters      map[string]func(interface{}) interface{}
+
+	deliveryMu   sync.Mutex
+	lastFlush    time.Time
+	lastFlushErr error
 }
 
 // Config configuration for the logging agent hook
@@ -64,6 +69,10 @@ type Config struct {
 	BatchSizeInLines    int
 	RequestSizeLimit    int
 	FlushInterval       time.Duration
+
+	// RequeueFailed keeps the entries of a failed flush for the next flush instead of dropping them,
+	// e.g. for the audit records. The pending entries grow while the logging hub is unavailable.
+	RequeueFailed bool
 }
 
 // pendingLogs allows for thread safe access to the slice of logs
@@ -86,9 +95,20 @@ func (pl *pendingLogs) flush() []*LoggingHubEntry {
 	defer pl.Unlock()
 	entries := pl.items[0:]
 	pl.items = []*LoggingHubEntry{}
+	pl.totalSize = 0
 	return entries
 }
 
+// requeue puts the entries of a failed flush back in front of the entries logged since
+func (pl *pendingLogs) requeue(entries []*LoggingHubEntry) {
+	pl.Lock()
+	defer pl.Unlock()
+	for _, entry := range entries {
+		pl.totalSize = pl.totalSize + getSize(entry)
+	}
+	pl.items = append(append([]*LoggingHubEntry{}, entries...), pl.items...)
+}
+
 // NewLoggingHubHook creates a new logging agent hook
 func NewLoggingHubHook(loggingHubURL string, senders []string) (*LoggingHubHook, error) {
 	return NewWithConfig(Config{
@@ -137,14 +157,39 @@ func NewWithConfig(conf Config) (*LoggingHubHook, error) {
 	return hook, nil
 }
 
-// Levels returns logging level to fire this hook.
+// Levels returns all the levels so logrus fires this hook for every entry, logrus only reads
+// the levels when the hook is added. Fire drops the entries not in the EnabledLevels.
 func (hook *LoggingHubHook) Levels() []logrus.Level {
-	return hook.levels
+	return logrus.AllLevels
+}
+
+// EnabledLevels returns logging level to fire this hook.
+func (hook *LoggingHubHook) EnabledLevels() []logrus.Level {
+	hook.levelsMu.RLock()
+	defer hook.levelsMu.RUnlock()
+
+	return append([]logrus.Level{}, hook.levels...)
 }
 
-// SetLevels sets logging level to fire this hook.
+// SetLevels sets logging level to fire this hook, it can be called after the hook is added to the logger.
 func (hook *LoggingHubHook) SetLevels(levels []logrus.Level) {
-	hook.levels = levels
+	hook.levelsMu.Lock()
+	defer hook.levelsMu.Unlock()
+
+	hook.levels = append([]logrus.Level{}, levels...)
+}
+
+func (hook *LoggingHubHook) levelEnabled(level logrus.Level) bool {
+	hook.levelsMu.RLock()
+	defer hook.levelsMu.RUnlock()
+
+	for _, l := range hook.levels {
+		if l == level {
+			return true
+		}
+	}
+
+	return false
 }
 
 // AddIgnore adds field name to ignore.
@@ -159,6 +204,10 @@ func (hook *LoggingHubHook) AddFilter(name string, fn func(interface{}) interfac
 
 // Fire is invoked by logrus and sends log to fluentd logger.
 func (hook *LoggingHubHook) Fire(entry *logrus.Entry) error {
+	if !hook.levelEnabled(entry.Level) {
+		return nil
+	}
+
 	loggingHubEntry := &LoggingHubEntry{
 		Log:    entry.Message,
 		Level:  entry.Level.String(),
@@ -186,6 +235,17 @@ func (hook *LoggingHubHook) Fire(entry *logrus.Entry) error {
 	return nil
 }
 
+// Send queues the entry for the logging hub regardless of the levels of the hook, e.g. for audit records.
+// It waits while the queue is full and returns the context error when the context is done first.
+func (hook *LoggingHubHook) Send(ctx context.Context, entry *LoggingHubEntry) error {
+	select {
+	case hook.channel <- entry:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Run handles time based operations
 func (hook *LoggingHubHook) Run() {
 	ticker := time.NewTicker(hook.config.FlushInterval)
@@ -216,6 +276,11 @@ func (hook *LoggingHubHook) Flush() {
 		Senders: hook.config.Senders,
 		Entries: entries,
 	})
+	if err != nil {
+		log.G(context.TODO()).WithError(err).Error("Error marshaling logs")
+		hook.setFlushResult(err)
+		return
+	}
 
 	client := &http.Client{
 		Timeout: defaultHTTPClientTimeout,
@@ -224,6 +289,9 @@ func (hook *LoggingHubHook) Flush() {
 	resp, err := client.Post(hook.config.LoggingHubURL, "application/json", bytes.NewBuffer(b))
 	if err != nil {
 		log.G(context.TODO()).WithError(err).Error("Error flushing logs")
+		hook.requeue(entries)
+		hook.setFlushResult(err)
+		return
 	}
 
 	if resp.StatusCode >= 300 {
@@ -233,7 +301,41 @@ func (hook *LoggingHubHook) Flush() {
 				"error":      getHTTPErrorMsg(resp),
 			},
 		).Errorf("Error posting logs")
+		hook.requeue(entries)
+		hook.setFlushResult(fmt.Errorf("logging hub responded with status code %d", resp.StatusCode))
+		return
 	}
+
+	resp.Body.Close()
+	hook.setFlushResult(nil)
+}
+
+// RequeueFailed returns whether the entries of a failed flush are sent again with the next flush
+func (hook *LoggingHubHook) RequeueFailed() bool {
+	return hook.config.RequeueFailed
+}
+
+func (hook *LoggingHubHook) requeue(entries []*LoggingHubEntry) {
+	if hook.config.RequeueFailed {
+		hook.pendingLogs.requeue(entries)
+	}
+}
+
+// LastFlush returns the time and the error of the last flush that sent logs,
+// the time is zero when no logs were sent yet
+func (hook *LoggingHubHook) LastFlush() (time.Time, error) {
+	hook.deliveryMu.Lock()
+	defer hook.deliveryMu.Unlock()
+
+	return hook.lastFlush, hook.lastFlushErr
+}
+
+func (hook *LoggingHubHook) setFlushResult(err error) {
+	hook.deliveryMu.Lock()
+	defer hook.deliveryMu.Unlock()
+
+	hook.lastFlush = time.Now()
+	hook.lastFlushErr = err
 }
 
 func getHTTPErrorMsg(resp *http.Response) string {
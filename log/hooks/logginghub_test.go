@@ -1 +1,63 @@
 package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushRequeueFailed(t *testing.T) {
+	var failing int32 = 1
+	var received []*LoggingHubEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var body LoggingHubEntriesReq
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		received = append(received, body.Entries...)
+	}))
+	defer server.Close()
+
+	hook, err := NewWithConfig(Config{
+		LoggingHubURL: server.URL,
+		Senders:       []string{"audit"},
+		FlushInterval: time.Hour,
+		RequeueFailed: true,
+	})
+	require.NoError(t, err)
+
+	hook.pendingLogs.appendLog(&LoggingHubEntry{Log: "first"})
+	hook.Flush()
+
+	_, flushErr := hook.LastFlush()
+	assert.Error(t, flushErr, "Should report the failed flush")
+
+	hook.pendingLogs.appendLog(&LoggingHubEntry{Log: "second"})
+	atomic.StoreInt32(&failing, 0)
+	hook.Flush()
+
+	if assert.Len(t, received, 2, "Should send the requeued entries with the next flush") {
+		assert.Equal(t, "first", received[0].Log, "Should keep the order of the entries")
+		assert.Equal(t, "second", received[1].Log)
+	}
+}
+
+func TestSendContext(t *testing.T) {
+	hook := &LoggingHubHook{channel: make(chan *LoggingHubEntry)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := hook.Send(ctx, &LoggingHubEntry{Log: "blocked"})
+	assert.Equal(t, context.DeadlineExceeded, err, "Should stop waiting for a full queue when the context is done")
+}
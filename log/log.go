@@ -18,7 +18,6 @@ package log
 
 import (
 	"context"
-	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 )
@@ -30,18 +29,15 @@ var (
 	// messages.
 	G = GetLogger
 
-	// L is an alias for the the standard logger.
-	L = logrus.NewEntry(logrus.StandardLogger())
+	// L is the default logger, it writes to the standard logrus logger.
+	// Replace it, e.g. with NewSlogLogger, to use another backend.
+	L = NewLogrusLogger(logrus.NewEntry(logrus.StandardLogger()))
 )
 
 type (
 	loggerKey struct{}
 )
 
-// TraceLevel is the log level for tracing. Trace level is lower than debug level,
-// and is usually used to trace detailed behavior of the program.
-const TraceLevel = logrus.Level(uint32(logrus.DebugLevel + 1))
-
 // RFC3339NanoFixed is time.RFC3339Nano with nanoseconds padded using zeros to
 // ensure the formatted time is always the same number of characters.
 const RFC3339NanoFixed = "2006-01-02T15:04:05.000000000Z07:00"
@@ -53,61 +49,49 @@ func init() {
 	})
 }
 
-// UseJSONOutput set the output format to JSON
+// UseJSONOutput set the output format of the standard logrus logger to JSON
 func UseJSONOutput() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 }
 
-// ParseLevel takes a string level and returns the Logrus log level constant.
-// It supports trace level.
-func ParseLevel(lvl string) (logrus.Level, error) {
-	if lvl == "trace" {
-		return TraceLevel, nil
-	}
-	return logrus.ParseLevel(lvl)
-}
-
-// SetLogLevel sets the logger level
+// SetLogLevel sets the level of the standard logrus logger
 func SetLogLevel(lvl string) error {
-	logrusLvl, err := ParseLevel(lvl)
+	level, err := ParseLevel(lvl)
 	if err != nil {
 		return err
 	}
 
-	logrus.SetLevel(logrusLvl)
+	logrus.SetLevel(logrus.Level(level))
 	return nil
 }
 
 // WithLogger returns a new context with the provided logger. Use in
 // combination with logger.WithField(s) for great effect.
-func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+func WithLogger(ctx context.Context, logger Logger) context.Context {
 	return context.WithValue(ctx, loggerKey{}, logger)
 }
 
 // GetLogger retrieves the current logger from the context. If no logger is
 // available, the default logger is returned.
-func GetLogger(ctx context.Context) *logrus.Entry {
-	logger := ctx.Value(loggerKey{})
-
-	if logger == nil {
+func GetLogger(ctx context.Context) Logger {
+	logger, ok := ctx.Value(loggerKey{}).(Logger)
+	if !ok {
 		return L
 	}
 
-	return logger.(*logrus.Entry)
+	return logger
 }
 
-// Trace logs a message at level Trace with the log entry passed-in.
-func Trace(e *logrus.Entry, args ...interface{}) {
-	level := logrus.Level(atomic.LoadUint32((*uint32)(&e.Logger.Level)))
-	if level >= TraceLevel {
-		e.Debug(args...)
+// Trace logs a message at level Trace with the logger passed-in.
+func Trace(l Logger, args ...interface{}) {
+	if l.GetLevel() >= TraceLevel {
+		l.Debug(args...)
 	}
 }
 
-// Tracef logs a message at level Trace with the log entry passed-in.
-func Tracef(e *logrus.Entry, format string, args ...interface{}) {
-	level := logrus.Level(atomic.LoadUint32((*uint32)(&e.Logger.Level)))
-	if level >= TraceLevel {
-		e.Debugf(format, args...)
+// Tracef logs a message at level Trace with the logger passed-in.
+func Tracef(l Logger, format string, args ...interface{}) {
+	if l.GetLevel() >= TraceLevel {
+		l.Debugf(format, args...)
 	}
 }
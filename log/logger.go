@@ -0,0 +1,78 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fields are the structured fields of a log entry. It is an alias so logrus.Fields can be passed as is.
+type Fields = map[string]interface{}
+
+// Level is the level of a log entry, the values match the logrus levels
+type Level uint32
+
+// The log levels from the most to the least severe
+const (
+	PanicLevel Level = iota
+	FatalLevel
+	ErrorLevel
+	WarnLevel
+	InfoLevel
+	DebugLevel
+
+	// TraceLevel is the log level for tracing. Trace level is lower than debug level,
+	// and is usually used to trace detailed behavior of the program.
+	TraceLevel
+)
+
+var levelNames = []string{"panic", "fatal", "error", "warning", "info", "debug", "trace"}
+
+// String returns the name of the level
+func (l Level) String() string {
+	if int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+
+	return "unknown"
+}
+
+// ParseLevel takes a string level and returns the log level constant.
+func ParseLevel(lvl string) (Level, error) {
+	name := strings.ToLower(lvl)
+	if name == "warn" {
+		name = "warning"
+	}
+
+	for i, levelName := range levelNames {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("not a valid log level: %q", lvl)
+}
+
+// Logger is the structured leveled logger used by go-core.
+// NewLogrusLogger and NewSlogLogger adapt the logrus and log/slog loggers.
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Warn(args ...interface{})
+	Warnf(format string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatal(args ...interface{})
+	Fatalf(format string, args ...interface{})
+
+	WithField(key string, value interface{}) Logger
+	WithFields(fields Fields) Logger
+	WithError(err error) Logger
+
+	// GetLevel returns the least severe level logged
+	GetLevel() Level
+
+	// WithLevel returns a copy of the logger logging at the level, the level of the logger is not changed
+	WithLevel(level Level) Logger
+}
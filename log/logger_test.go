@@ -0,0 +1,66 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, WarnLevel, level)
+	assert.Equal(t, "warning", level.String())
+
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err, "Should reject unknown levels")
+}
+
+func TestLogrusLogger(t *testing.T) {
+	base, hook := logrustest.NewNullLogger()
+	base.SetLevel(logrus.InfoLevel)
+
+	logger := NewLogrusLogger(logrus.NewEntry(base)).WithFields(Fields{"service": "test"})
+	assert.Equal(t, InfoLevel, logger.GetLevel())
+
+	logger.Debug("dropped")
+	assert.Empty(t, hook.AllEntries(), "Should not log below the level")
+
+	logger.WithLevel(WarnLevel).Info("dropped")
+	assert.Empty(t, hook.AllEntries(), "Should use the lowered level")
+
+	escalated := logger.WithLevel(DebugLevel)
+	assert.Equal(t, DebugLevel, escalated.GetLevel())
+	escalated.WithField("key", "value").Debug("kept")
+	assert.Equal(t, InfoLevel, logger.GetLevel(), "Should not change the level of the logger")
+	assert.Equal(t, logrus.InfoLevel, base.GetLevel(), "Should not change the level of the logrus logger")
+
+	entry := hook.LastEntry()
+	if assert.NotNil(t, entry, "Should write the escalated logs through the logrus logger") {
+		assert.Equal(t, logrus.DebugLevel, entry.Level)
+		assert.Equal(t, "test", entry.Data["service"])
+		assert.Equal(t, "value", entry.Data["key"])
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	assert.Equal(t, InfoLevel, logger.GetLevel())
+
+	logger.Debug("dropped")
+	assert.Empty(t, buf.String(), "Should not log below the level")
+
+	logger.WithLevel(TraceLevel).WithFields(Fields{"service": "test"}).Debugf("kept %d", 1)
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "kept 1", record["msg"])
+	assert.Equal(t, "DEBUG", record["level"])
+	assert.Equal(t, "test", record["service"])
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logrusLogger adapts a logrus entry to the Logger, the level is the level of the logrus logger of the entry
+type logrusLogger struct {
+	entry *logrus.Entry
+
+	// base is the logrus logger the entry was created with, the entry uses a leveled copy of it after WithLevel
+	base *logrus.Logger
+}
+
+// NewLogrusLogger returns a Logger writing to the logrus entry
+func NewLogrusLogger(entry *logrus.Entry) Logger {
+	return &logrusLogger{entry: entry, base: entry.Logger}
+}
+
+// leveledLogrusLogger returns a logrus logger at the level writing to the output and hooks of the base logger,
+// the level of the base logger is not changed. Set up the output, formatter and hooks of the base logger before
+// calling it, the returned logger does not see the later changes of the output and formatter.
+func leveledLogrusLogger(base *logrus.Logger, level Level) *logrus.Logger {
+	if Level(base.GetLevel()) == level {
+		return base
+	}
+
+	return &logrus.Logger{
+		Out:          base.Out,
+		Hooks:        base.Hooks,
+		Formatter:    base.Formatter,
+		ReportCaller: base.ReportCaller,
+		Level:        logrus.Level(level),
+		ExitFunc:     base.ExitFunc,
+	}
+}
+
+// NewJSONLogger returns a Logger writing JSON entries of every level to the writer
+func NewJSONLogger(w io.Writer) Logger {
+	logger := logrus.New()
+	logger.Out = w
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.TraceLevel
+
+	return NewLogrusLogger(logrus.NewEntry(logger))
+}
+
+// LogrusEntry returns the logrus entry of a Logger created by NewLogrusLogger
+func LogrusEntry(logger Logger) (*logrus.Entry, bool) {
+	l, ok := logger.(*logrusLogger)
+	if !ok {
+		return nil, false
+	}
+
+	return l.entry, true
+}
+
+func (l *logrusLogger) log(level Level, args ...interface{}) {
+	l.entry.Log(logrus.Level(level), args...)
+}
+
+func (l *logrusLogger) logf(level Level, format string, args ...interface{}) {
+	l.entry.Logf(logrus.Level(level), format, args...)
+}
+
+func (l *logrusLogger) Debug(args ...interface{}) { l.log(DebugLevel, args...) }
+
+func (l *logrusLogger) Debugf(format string, args ...interface{}) {
+	l.logf(DebugLevel, format, args...)
+}
+
+func (l *logrusLogger) Info(args ...interface{}) { l.log(InfoLevel, args...) }
+
+func (l *logrusLogger) Infof(format string, args ...interface{}) { l.logf(InfoLevel, format, args...) }
+
+func (l *logrusLogger) Warn(args ...interface{}) { l.log(WarnLevel, args...) }
+
+func (l *logrusLogger) Warnf(format string, args ...interface{}) { l.logf(WarnLevel, format, args...) }
+
+func (l *logrusLogger) Error(args ...interface{}) { l.log(ErrorLevel, args...) }
+
+func (l *logrusLogger) Errorf(format string, args ...interface{}) {
+	l.logf(ErrorLevel, format, args...)
+}
+
+func (l *logrusLogger) Fatal(args ...interface{}) {
+	l.log(FatalLevel, args...)
+	l.entry.Logger.Exit(1)
+}
+
+func (l *logrusLogger) Fatalf(format string, args ...interface{}) {
+	l.logf(FatalLevel, format, args...)
+	l.entry.Logger.Exit(1)
+}
+
+func (l *logrusLogger) WithField(key string, value interface{}) Logger {
+	return &logrusLogger{entry: l.entry.WithField(key, value), base: l.base}
+}
+
+func (l *logrusLogger) WithFields(fields Fields) Logger {
+	return &logrusLogger{entry: l.entry.WithFields(fields), base: l.base}
+}
+
+func (l *logrusLogger) WithError(err error) Logger {
+	return &logrusLogger{entry: l.entry.WithError(err), base: l.base}
+}
+
+// GetLevel returns the level of the logrus logger of the entry
+func (l *logrusLogger) GetLevel() Level {
+	return Level(l.entry.Logger.GetLevel())
+}
+
+// WithLevel returns a logger with the level writing to the output and hooks of the logrus logger,
+// e.g. to escalate the logs of a request. The level of the logrus logger is not changed.
+func (l *logrusLogger) WithLevel(level Level) Logger {
+	entry := &logrus.Entry{
+		Logger:  leveledLogrusLogger(l.base, level),
+		Data:    l.entry.Data,
+		Time:    l.entry.Time,
+		Context: l.entry.Context,
+	}
+
+	return &logrusLogger{entry: entry, base: l.base}
+}
@@ -0,0 +1,142 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// slogLogger adapts a log/slog logger to the Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to the slog logger.
+// The trace, fatal and panic levels are mapped 4 below debug, 4 and 8 above error.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+// SlogLevel returns the slog level of the level
+func SlogLevel(level Level) slog.Level {
+	switch level {
+	case PanicLevel:
+		return slog.LevelError + 8
+	case FatalLevel:
+		return slog.LevelError + 4
+	case ErrorLevel:
+		return slog.LevelError
+	case WarnLevel:
+		return slog.LevelWarn
+	case InfoLevel:
+		return slog.LevelInfo
+	case DebugLevel:
+		return slog.LevelDebug
+	default:
+		return slog.LevelDebug - 4
+	}
+}
+
+func (l *slogLogger) log(level Level, msg string) {
+	l.logger.Log(context.Background(), SlogLevel(level), msg)
+}
+
+func (l *slogLogger) Debug(args ...interface{}) { l.log(DebugLevel, fmt.Sprint(args...)) }
+
+func (l *slogLogger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Info(args ...interface{}) { l.log(InfoLevel, fmt.Sprint(args...)) }
+
+func (l *slogLogger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Warn(args ...interface{}) { l.log(WarnLevel, fmt.Sprint(args...)) }
+
+func (l *slogLogger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Error(args ...interface{}) { l.log(ErrorLevel, fmt.Sprint(args...)) }
+
+func (l *slogLogger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Fatal(args ...interface{}) {
+	l.log(FatalLevel, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l *slogLogger) Fatalf(format string, args ...interface{}) {
+	l.log(FatalLevel, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *slogLogger) WithField(key string, value interface{}) Logger {
+	return &slogLogger{logger: l.logger.With(key, value)}
+}
+
+func (l *slogLogger) WithFields(fields Fields) Logger {
+	args := make([]interface{}, 0, len(fields))
+	for _, k := range sortedKeys(fields) {
+		args = append(args, slog.Any(k, fields[k]))
+	}
+
+	return &slogLogger{logger: l.logger.With(args...)}
+}
+
+func (l *slogLogger) WithError(err error) Logger {
+	return &slogLogger{logger: l.logger.With(slog.Any("error", err))}
+}
+
+// GetLevel returns the least severe level enabled by the handler
+func (l *slogLogger) GetLevel() Level {
+	for level := TraceLevel; level > PanicLevel; level-- {
+		if l.logger.Enabled(context.Background(), SlogLevel(level)) {
+			return level
+		}
+	}
+
+	return PanicLevel
+}
+
+func (l *slogLogger) WithLevel(level Level) Logger {
+	return &slogLogger{logger: slog.New(&levelHandler{
+		Handler: l.logger.Handler(),
+		level:   SlogLevel(level),
+	})}
+}
+
+// levelHandler overrides the level of the handler it wraps
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// sortedKeys returns the keys of the fields sorted so the output is stable
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
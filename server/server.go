@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/samkreter/go-core/log"
+)
+
+const (
+	defaultReadTimeout       = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultDrainDelay        = 5 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultHookTimeout       = 10 * time.Second
+)
+
+// Config holds the configuration for the Server
+type Config struct {
+	Addr    string
+	Handler http.Handler
+
+	// TLSConfig serves TLS when set, e.g. the ServerTLSConfig of a httputil.TLSReloader
+	TLSConfig *tls.Config
+
+	// The timeouts of the http.Server, they default to 30s read and write, 10s read header and 120s idle
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// DrainDelay is how long the server keeps serving after failing readiness so the
+	// load balancers stop sending it requests, defaults to 5s. Use a negative value to disable it.
+	DrainDelay time.Duration
+
+	// ShutdownTimeout is how long the in-flight requests have to complete, defaults to 30s
+	ShutdownTimeout time.Duration
+
+	// HookTimeout is how long each shutdown hook has to complete, defaults to 10s
+	HookTimeout time.Duration
+
+	// Signals start the shutdown, defaults to SIGINT and SIGTERM
+	Signals []os.Signal
+}
+
+// ShutdownHook runs after the server stopped serving requests, e.g. to flush the trace exporters
+type ShutdownHook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	hook ShutdownHook
+}
+
+// Server is an http.Server that shuts down gracefully on the configured signals
+type Server struct {
+	config     Config
+	httpServer *http.Server
+
+	ready int32
+
+	mu    sync.Mutex
+	hooks []namedHook
+}
+
+// New creates a new Server from the config
+func New(config Config) (*Server, error) {
+	if config.Handler == nil {
+		return nil, errors.New("configuration must have a Handler")
+	}
+
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = defaultReadTimeout
+	}
+
+	if config.ReadHeaderTimeout == 0 {
+		config.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = defaultWriteTimeout
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = defaultIdleTimeout
+	}
+
+	if config.DrainDelay == 0 {
+		config.DrainDelay = defaultDrainDelay
+	}
+
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	if config.HookTimeout == 0 {
+		config.HookTimeout = defaultHookTimeout
+	}
+
+	if len(config.Signals) == 0 {
+		config.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	return &Server{
+		config: config,
+		httpServer: &http.Server{
+			Addr:              config.Addr,
+			Handler:           config.Handler,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			TLSConfig:         config.TLSConfig,
+		},
+	}, nil
+}
+
+// OnShutdown adds a hook that runs after the server stopped serving requests,
+// the hooks run in the order they were added
+func (s *Server) OnShutdown(name string, hook ShutdownHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks = append(s.hooks, namedHook{name: name, hook: hook})
+}
+
+// Ready returns true while the server is serving and not shutting down
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// Run listens on the configured address and serves until a signal is received or the context is done
+func (s *Server) Run(ctx context.Context) error {
+	addr := s.config.Addr
+	if addr == "" {
+		addr = ":http"
+		if s.config.TLSConfig != nil {
+			addr = ":https"
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on the listener until a signal is received or the context is done, then shuts down
+// gracefully: it fails readiness, waits the drain delay, waits for the in-flight requests and
+// runs the shutdown hooks.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	logger := log.G(ctx).WithField("address", listener.Addr().String())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, s.config.Signals...)
+	defer signal.Stop(signals)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.config.TLSConfig != nil {
+			// The certificates are in the TLSConfig
+			serveErr <- s.httpServer.ServeTLS(listener, "", "")
+			return
+		}
+
+		serveErr <- s.httpServer.Serve(listener)
+	}()
+
+	atomic.StoreInt32(&s.ready, 1)
+	logger.Info("Server started")
+
+	select {
+	case err := <-serveErr:
+		atomic.StoreInt32(&s.ready, 0)
+		logger.WithError(err).Error("Server failed")
+		return err
+	case sig := <-signals:
+		logger.WithField("signal", sig.String()).Info("Received shutdown signal")
+	case <-ctx.Done():
+		logger.Info("Context done, shutting down")
+	}
+
+	start := time.Now()
+	atomic.StoreInt32(&s.ready, 0)
+
+	if s.config.DrainDelay > 0 {
+		logger.WithField("drainDelay", s.config.DrainDelay.String()).Info("Failed readiness, draining")
+		time.Sleep(s.config.DrainDelay)
+	}
+
+	logger.WithField("shutdownTimeout", s.config.ShutdownTimeout.String()).Info("Shutting down server")
+
+	// The context of the Run is done when shutting down on cancellation, the shutdown gets its own deadline
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	err := s.httpServer.Shutdown(shutdownCtx)
+	cancel()
+	if err != nil {
+		logger.WithError(err).Error("Failed to wait for the in-flight requests")
+	}
+
+	s.runHooks(logger)
+
+	logger.WithField("durationInMilliseconds", float64(time.Now().Sub(start))/float64(time.Millisecond)).Info("Server stopped")
+	return err
+}
+
+func (s *Server) runHooks(logger log.Logger) {
+	s.mu.Lock()
+	hooks := append([]namedHook{}, s.hooks...)
+	s.mu.Unlock()
+
+	for _, h := range hooks {
+		hookLogger := logger.WithField("hook", h.name)
+		hookLogger.Debug("Running shutdown hook")
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.HookTimeout)
+		if err := h.hook(ctx); err != nil {
+			hookLogger.WithError(err).Error("Shutdown hook failed")
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(time.Millisecond * 200)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	srv, err := New(Config{
+		Handler:    handler,
+		DrainDelay: time.Millisecond * 50,
+		Signals:    []os.Signal{syscall.SIGUSR1},
+	})
+	require.NoError(t, err, "Should not get error creating the server")
+
+	var hooks []string
+	srv.OnShutdown("first", func(ctx context.Context) error {
+		hooks = append(hooks, "first")
+		return nil
+	})
+	srv.OnShutdown("second", func(ctx context.Context) error {
+		hooks = append(hooks, "second")
+		return nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	logrus.SetLevel(logrus.DebugLevel)
+	testHook := logrustest.NewGlobal()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(context.Background(), listener)
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	<-started
+	assert.True(t, srv.Ready(), "Should be ready while serving")
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+
+	require.NoError(t, <-done, "Should shut down without error")
+	assert.Equal(t, http.StatusAccepted, <-status, "Should complete the in-flight request")
+	assert.False(t, srv.Ready(), "Should fail readiness after shutdown")
+	assert.Equal(t, []string{"first", "second"}, hooks, "Should run the hooks in order")
+
+	var messages []string
+	for _, entry := range testHook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "Received shutdown signal")
+	assert.Contains(t, messages, "Failed readiness, draining")
+	assert.Equal(t, "Server stopped", testHook.LastEntry().Message)
+}
+
+func TestServerContextDone(t *testing.T) {
+	srv, err := New(Config{
+		Handler:    http.NotFoundHandler(),
+		DrainDelay: -1,
+	})
+	require.NoError(t, err, "Should not get error creating the server")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(ctx, listener)
+	}()
+
+	cancel()
+	assert.NoError(t, <-done, "Should shut down when the context is done")
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err, "Should stop listening")
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 
 	"contrib.go.opencensus.io/exporter/ocagent"
 	"go.opencensus.io/exporter/jaeger"
@@ -16,6 +17,21 @@ const (
 	defaultJaegerCollectorEndpoint = "http://localhost:14268/api/traces"
 )
 
+var (
+	samplerMu sync.Mutex
+
+	// samplingProbability is the probability of the default sampler, opencensus does not expose it
+	samplingProbability = 1.0
+
+	flushersMu sync.Mutex
+
+	// flushers flush the spans buffered by the registered exporters
+	flushers []func()
+
+	// stoppers stop the registered exporters holding connections, they are only called once by Stop
+	stoppers []func()
+)
+
 func StartSpan(ctx context.Context, name string) (context.Context, *trace.Span) {
 	return trace.StartSpan(ctx, name)
 }
@@ -35,7 +51,9 @@ func StartSpanWithTags(ctx context.Context, name string, tags map[string]string)
 
 // SetupTracing setup default tracing
 func SetupTracing(serviceName string, exporters ...string) error {
-	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	if err := SetSamplingProbability(1); err != nil {
+		return err
+	}
 
 	for _, exporter := range exporters {
 		switch exporter {
@@ -51,6 +69,28 @@ func SetupTracing(serviceName string, exporters ...string) error {
 	return nil
 }
 
+// SetSamplingProbability sets the probability of the default sampler, between 0 and 1
+func SetSamplingProbability(fraction float64) error {
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("sampling probability must be between 0 and 1: %v", fraction)
+	}
+
+	samplerMu.Lock()
+	defer samplerMu.Unlock()
+
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(fraction)})
+	samplingProbability = fraction
+	return nil
+}
+
+// SamplingProbability returns the probability of the default sampler
+func SamplingProbability() float64 {
+	samplerMu.Lock()
+	defer samplerMu.Unlock()
+
+	return samplingProbability
+}
+
 // RegisterAppInsightsExportor registers the app insights exporter
 func RegisterAppInsightsExportor(serviceName, agentEndpoint string) error {
 	exporter, err := ocagent.NewExporter(ocagent.WithInsecure(), ocagent.WithServiceName(serviceName), ocagent.WithAddress(agentEndpoint))
@@ -59,6 +99,11 @@ func RegisterAppInsightsExportor(serviceName, agentEndpoint string) error {
 	}
 
 	trace.RegisterExporter(exporter)
+	addFlusher(exporter.Flush)
+	addStopper(func() {
+		trace.UnregisterExporter(exporter)
+		exporter.Stop()
+	})
 	return nil
 }
 
@@ -82,9 +127,52 @@ func RegisterJaegerExporter(serviceName string) error {
 	}
 
 	trace.RegisterExporter(exporter)
+	addFlusher(exporter.Flush)
 	return nil
 }
 
+// Flush sends the spans buffered by the registered exporters, it can be called any number of times
+func Flush() {
+	flushersMu.Lock()
+	defer flushersMu.Unlock()
+
+	for _, flush := range flushers {
+		flush()
+	}
+}
+
+// Stop flushes and stops the registered exporters, it should be called before the process exits.
+// The exporters are unregistered so calling Stop or Flush again has no effect.
+func Stop() {
+	flushersMu.Lock()
+	defer flushersMu.Unlock()
+
+	for _, flush := range flushers {
+		flush()
+	}
+
+	for _, stop := range stoppers {
+		stop()
+	}
+
+	flushers = nil
+	stoppers = nil
+}
+
+func addFlusher(flush func()) {
+	flushersMu.Lock()
+	defer flushersMu.Unlock()
+
+	flushers = append(flushers, flush)
+}
+
+func addStopper(stop func()) {
+	flushersMu.Lock()
+	defer flushersMu.Unlock()
+
+	stoppers = append(stoppers, stop)
+}
+
 func addStdoutExporter() {
 	trace.RegisterExporter(new(stdoutExporter))
 }